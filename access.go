@@ -0,0 +1,316 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// parsed authorize expression, see AuthorizeProperty
+//
+//	expr    = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | "(" expr ")" | call
+//	call    = name "(" [ arg { "," arg } ] ")"
+//	arg     = 'string' | "string" | :variable | word
+type accessExpr interface {
+	eval(ctx *RequestCtx, call func(name string, args []string) (bool, error)) (bool, error)
+
+	// names of the functions called
+	functions(visit func(name string, args int))
+}
+
+type accessOr []accessExpr
+
+type accessAnd []accessExpr
+
+type accessNot struct {
+	expr accessExpr
+}
+
+type accessCall struct {
+	name string
+	args []accessArg
+}
+
+// literal, or path variable if variable is set
+type accessArg struct {
+	value    string
+	variable bool
+}
+
+func (e accessOr) eval(ctx *RequestCtx, call func(string, []string) (bool, error)) (bool, error) {
+	for _, expr := range e {
+		ok, err := expr.eval(ctx, call)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func (e accessOr) functions(visit func(string, int)) {
+	for _, expr := range e {
+		expr.functions(visit)
+	}
+}
+
+func (e accessAnd) eval(ctx *RequestCtx, call func(string, []string) (bool, error)) (bool, error) {
+	for _, expr := range e {
+		ok, err := expr.eval(ctx, call)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (e accessAnd) functions(visit func(string, int)) {
+	for _, expr := range e {
+		expr.functions(visit)
+	}
+}
+
+func (e accessNot) eval(ctx *RequestCtx, call func(string, []string) (bool, error)) (bool, error) {
+	ok, err := e.expr.eval(ctx, call)
+	return !ok && err == nil, err
+}
+
+func (e accessNot) functions(visit func(string, int)) {
+	e.expr.functions(visit)
+}
+
+// path variables are resolved before the call
+func (e accessCall) eval(ctx *RequestCtx, call func(string, []string) (bool, error)) (bool, error) {
+	args := make([]string, len(e.args))
+	for i, arg := range e.args {
+		if arg.variable {
+			args[i] = ctx.PathVariable[arg.value]
+		} else {
+			args[i] = arg.value
+		}
+	}
+	return call(e.name, args)
+}
+
+func (e accessCall) functions(visit func(string, int)) {
+	visit(e.name, len(e.args))
+}
+
+type accessParser struct {
+	expr   string
+	tokens []string
+	pos    int
+}
+
+// syntax of the expression, functions are checked by the authorize interceptor
+//
+//	eg: parseAccess("hasRole('admin') && owner(:id)")
+func parseAccess(expr string) (accessExpr, error) {
+	tokens, err := tokenizeAccess(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &accessParser{expr: expr, tokens: tokens}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected %s", p.tokens[p.pos])
+	}
+	return e, nil
+}
+
+func (p *accessParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid authorize expression %q: %s", p.expr, fmt.Sprintf(format, args...))
+}
+
+func (p *accessParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *accessParser) expect(token string) error {
+	if p.peek() != token {
+		if p.pos == len(p.tokens) {
+			return p.errorf("%s expected at the end", token)
+		}
+		return p.errorf("%s expected instead of %s", token, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *accessParser) or() (accessExpr, error) {
+	var or accessOr
+	for {
+		e, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, e)
+		if p.peek() != "||" {
+			break
+		}
+		p.pos++
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+func (p *accessParser) and() (accessExpr, error) {
+	var and accessAnd
+	for {
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, e)
+		if p.peek() != "&&" {
+			break
+		}
+		p.pos++
+	}
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return and, nil
+}
+
+func (p *accessParser) unary() (accessExpr, error) {
+	switch token := p.peek(); {
+	case token == "!":
+		p.pos++
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return accessNot{e}, nil
+	case token == "(":
+		p.pos++
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	case isAccessWord(token):
+		p.pos++
+		call := accessCall{name: token}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		for p.peek() != ")" {
+			if len(call.args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.arg()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+		}
+		p.pos++
+		return call, nil
+	case token == "":
+		return nil, p.errorf("unexpected end")
+	default:
+		return nil, p.errorf("unexpected %s", token)
+	}
+}
+
+func (p *accessParser) arg() (accessArg, error) {
+	token := p.peek()
+	switch {
+	case token == "":
+		return accessArg{}, p.errorf("unexpected end")
+	case token[0] == '\'' || token[0] == '"':
+		p.pos++
+		return accessArg{value: token[1 : len(token)-1]}, nil
+	case token[0] == ':' && isAccessWord(token[1:]):
+		p.pos++
+		return accessArg{value: token[1:], variable: true}, nil
+	case isAccessWord(token):
+		p.pos++
+		return accessArg{value: token}, nil
+	default:
+		return accessArg{}, p.errorf("unexpected %s", token)
+	}
+}
+
+// operators, parentheses, commas, quoted strings, variables and words
+func tokenizeAccess(expr string) ([]string, error) {
+	var tokens []string
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',':
+			tokens = append(tokens, string(r))
+			i++
+		case r == '!':
+			tokens = append(tokens, "!")
+			i++
+		case r == '&' || r == '|':
+			if i+1 >= len(runes) || runes[i+1] != r {
+				return nil, fmt.Errorf("invalid authorize expression %q: %c%c expected", expr, r, r)
+			}
+			tokens = append(tokens, string([]rune{r, r}))
+			i += 2
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("invalid authorize expression %q: unterminated string", expr)
+			}
+			tokens = append(tokens, string(runes[i:end+1]))
+			i = end + 1
+		default:
+			end := i
+			if r == ':' {
+				end++
+			}
+			for end < len(runes) && isAccessRune(runes[end]) {
+				end++
+			}
+			if end == i || (r == ':' && end == i+1) {
+				return nil, fmt.Errorf("invalid authorize expression %q: unexpected %c", expr, r)
+			}
+			tokens = append(tokens, string(runes[i:end]))
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+func isAccessRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-.", r)
+}
+
+func isAccessWord(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, r := range token {
+		if !isAccessRune(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"fmt"
+	"github.com/kinoko-projects/kinoko"
+	"net/http"
+	"strings"
+)
+
+// property key of the authentication of a route
+// true requires a principal of any scheme, a scheme name or a []string of scheme names requires one of them,
+// false skips authentication, routes without it require a principal if kinoko.web.auth.required is set
+// or if they have RolesProperty or AuthorizeProperty
+//
+//	eg: s.GET("/me", handler, NewProperty(AuthProperty, true))
+//		s.POST("/hooks", handler, NewProperty(AuthProperty, "api-key"))
+const AuthProperty = "auth"
+
+// authentication of requests, credentials sent to routes not requiring them are still verified
+//
+//	kinoko:
+//	  web:
+//	    auth:
+//	      required: false
+//	      realm: kinoko
+//	      api-key:
+//	        header: X-API-Key
+//	        query: api_key
+//	      jwt:
+//	        secret: ""
+//	        keys: keys/rsa.pem, keys/ec.pem
+//	        jwks: keys/jwks.json
+//	        issuer: https://auth.example.com
+//	        audience: api
+//	        leeway: 30
+//	        roles-claim: roles
+//
+// "basic" is enabled by a CredentialProvider spore, "api-key" by an APIKeyProvider spore,
+// "jwt" by any of jwt secret, keys or jwks, other schemes by Authenticator spores
+type AuthConfig struct {
+	Required bool   `inject:"kinoko.web.auth.required:false"`
+	Realm    string `inject:"kinoko.web.auth.realm:kinoko"`
+
+	APIKeyHeader string `inject:"kinoko.web.auth.api-key.header:X-API-Key"`
+	APIKeyQuery  string `inject:"kinoko.web.auth.api-key.query:"`
+
+	// HS256 secret
+	JWTSecret string `inject:"kinoko.web.auth.jwt.secret:"`
+	// PEM files of RS256 and ES256 public keys or certificates, comma separated
+	JWTKeys string `inject:"kinoko.web.auth.jwt.keys:"`
+	JWKS    string `inject:"kinoko.web.auth.jwt.jwks:"`
+	// checked if not empty
+	JWTIssuer   string `inject:"kinoko.web.auth.jwt.issuer:"`
+	JWTAudience string `inject:"kinoko.web.auth.jwt.audience:"`
+	// seconds of clock skew tolerated by exp and nbf
+	JWTLeeway     int    `inject:"kinoko.web.auth.jwt.leeway:0"`
+	JWTRolesClaim string `inject:"kinoko.web.auth.jwt.roles-claim:roles"`
+}
+
+// authenticated client of a request, see RequestCtx.Principal
+type Principal struct {
+	Name string
+
+	// scheme which authenticated the principal, set by the server if empty
+	Scheme string
+	Roles  []string
+
+	// claims of a token or attributes given by a provider
+	Claims map[string]interface{}
+}
+
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// authentication scheme, register it as a kinoko spore
+type Authenticator interface {
+	// name referred by AuthProperty, eg: "basic"
+	Scheme() string
+
+	// nil principal without error if the request carries no credentials of the scheme
+	// *ErrInvalidCredentials rejects the request as 401, eg: an expired token
+	// any other error is a failure of the authenticator, responded as 500 and logged
+	Authenticate(ctx *RequestCtx) (*Principal, error)
+
+	// WWW-Authenticate challenge of responses requiring authentication, empty for none
+	//	eg: `Basic realm="kinoko"`
+	Challenge(realm string) string
+}
+
+// returned by authenticators when the credentials of the request are rejected, responded as 401
+type ErrInvalidCredentials struct {
+	Reason string
+}
+
+func (e *ErrInvalidCredentials) Error() string {
+	return e.Reason
+}
+
+// principal of the request, nil if not authenticated
+func (c *RequestCtx) Principal() *Principal {
+	return c.principal
+}
+
+// global interceptor authenticating requests before other interceptors
+// it is named "auth" so interceptors can be ordered after it, see OrderedInterceptor
+type authInterceptor struct {
+	realm          string
+	required       bool
+	authenticators []Authenticator
+}
+
+func (a *authInterceptor) Priority() int {
+	return -1000
+}
+
+func (a *authInterceptor) Name() string {
+	return "auth"
+}
+
+func (a *authInterceptor) Before() []string {
+	return nil
+}
+
+func (a *authInterceptor) After() []string {
+	return nil
+}
+
+func (a *authInterceptor) Intercept(ctx *RequestCtx, properties map[string]interface{}) (InterceptorAction, interface{}) {
+	if enabled, ok := properties[AuthProperty].(bool); ok && !enabled {
+		return Continue, nil
+	}
+	required, authenticators := a.policy(properties)
+	for _, authenticator := range authenticators {
+		principal, e := authenticator.Authenticate(ctx)
+		if invalid, ok := e.(*ErrInvalidCredentials); ok {
+			return Block, a.unauthorized(authenticators, invalid.Reason)
+		}
+		// the cause may reveal internals, eg: a database error
+		if e != nil {
+			logger.Error("Failed to authenticate by", authenticator.Scheme(), "-", e.Error())
+			return Block, InternalServerError("authentication failed")
+		}
+		if principal != nil {
+			if principal.Scheme == "" {
+				principal.Scheme = authenticator.Scheme()
+			}
+			ctx.principal = principal
+			return Continue, nil
+		}
+	}
+	if required {
+		return Block, a.unauthorized(authenticators, "authentication required")
+	}
+	return Continue, nil
+}
+
+// authenticators accepted by the route and whether a principal is required
+func (a *authInterceptor) policy(properties map[string]interface{}) (bool, []Authenticator) {
+	var schemes []string
+	switch v := properties[AuthProperty].(type) {
+	case nil:
+		_, guarded := properties[RolesProperty]
+		_, hasExpression := properties[AuthorizeProperty]
+		return a.required || guarded || hasExpression, a.authenticators
+	case bool:
+		return true, a.authenticators
+	case string:
+		schemes = []string{v}
+	case []string:
+		schemes = v
+	default:
+		logger.Warn("Invalid auth property", v, ", authentication is required")
+		return true, a.authenticators
+	}
+	accepted := []Authenticator{}
+	for _, authenticator := range a.authenticators {
+		for _, scheme := range schemes {
+			if strings.EqualFold(authenticator.Scheme(), scheme) {
+				accepted = append(accepted, authenticator)
+				break
+			}
+		}
+	}
+	return true, accepted
+}
+
+// 401 challenging the accepted schemes
+func (a *authInterceptor) unauthorized(authenticators []Authenticator, message string) *Response {
+	response := Status(http.StatusUnauthorized, Unauthorized(message).WithCode("UNAUTHORIZED"))
+	for _, authenticator := range authenticators {
+		if challenge := authenticator.Challenge(a.realm); challenge != "" {
+			response.Header("WWW-Authenticate", challenge)
+		}
+	}
+	return response
+}
+
+// authenticators of the configured schemes followed by Authenticator spores
+func (c *AuthConfig) authenticators() ([]Authenticator, error) {
+	var authenticators []Authenticator
+	if c.JWTSecret != "" || c.JWTKeys != "" || c.JWKS != "" {
+		keys, e := LoadJWTKeys(c.JWTSecret, splitList(c.JWTKeys), c.JWKS)
+		if e != nil {
+			return nil, fmt.Errorf("failed to load jwt keys: %v", e)
+		}
+		authenticators = append(authenticators, &JWTAuthenticator{
+			Keys:       keys,
+			Issuer:     c.JWTIssuer,
+			Audience:   c.JWTAudience,
+			Leeway:     c.JWTLeeway,
+			RolesClaim: c.JWTRolesClaim,
+		})
+	}
+	credentialProviders := kinoko.Application.GetImplementedSpores((*CredentialProvider)(nil))
+	sortSpores(credentialProviders)
+	for _, provider := range credentialProviders {
+		authenticators = append(authenticators, &BasicAuthenticator{Provider: provider.(CredentialProvider)})
+	}
+	apiKeyProviders := kinoko.Application.GetImplementedSpores((*APIKeyProvider)(nil))
+	sortSpores(apiKeyProviders)
+	for _, provider := range apiKeyProviders {
+		authenticators = append(authenticators, &APIKeyAuthenticator{Provider: provider.(APIKeyProvider), Header: c.APIKeyHeader, Query: c.APIKeyQuery})
+	}
+	spores := kinoko.Application.GetImplementedSpores((*Authenticator)(nil))
+	sortSpores(spores)
+	for _, authenticator := range spores {
+		authenticators = append(authenticators, authenticator.(Authenticator))
+	}
+	return authenticators, nil
+}
+
+// the auth interceptor is added if any scheme is enabled or authentication is required
+func (s *HttpServer) authInterceptor() (*authInterceptor, error) {
+	if s.AuthConfig == nil {
+		return nil, nil
+	}
+	authenticators, e := s.AuthConfig.authenticators()
+	if e != nil {
+		return nil, e
+	}
+	if len(authenticators) == 0 && !s.AuthConfig.Required {
+		return nil, nil
+	}
+	for _, authenticator := range authenticators {
+		logger.Info("Authentication scheme", authenticator.Scheme())
+	}
+	return &authInterceptor{realm: s.AuthConfig.Realm, required: s.AuthConfig.Required, authenticators: authenticators}, nil
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"fmt"
+	"strings"
+)
+
+// verifies the credentials of HTTP Basic, register it as a kinoko spore to enable the "basic" scheme
+type CredentialProvider interface {
+	// nil principal without error if the credentials are invalid, an error is responded as 500
+	// passwords should be compared in constant time, eg: subtle.ConstantTimeCompare or bcrypt
+	VerifyCredentials(username string, password string) (*Principal, error)
+}
+
+// resolves API keys, register it as a kinoko spore to enable the "api-key" scheme
+type APIKeyProvider interface {
+	// nil principal without error if the key is unknown, an error is responded as 500
+	LookupAPIKey(key string) (*Principal, error)
+}
+
+// "basic" scheme, the credentials of the Authorization header are verified by the provider
+type BasicAuthenticator struct {
+	Provider CredentialProvider
+}
+
+func (b *BasicAuthenticator) Scheme() string {
+	return "basic"
+}
+
+func (b *BasicAuthenticator) Authenticate(ctx *RequestCtx) (*Principal, error) {
+	if !hasAuthorization(ctx, "Basic") {
+		return nil, nil
+	}
+	username, password, ok := ctx.Request.BasicAuth()
+	if !ok {
+		return nil, &ErrInvalidCredentials{Reason: "malformed basic credentials"}
+	}
+	principal, e := b.Provider.VerifyCredentials(username, password)
+	if e != nil {
+		return nil, e
+	}
+	if principal == nil {
+		return nil, &ErrInvalidCredentials{Reason: "invalid credentials"}
+	}
+	if principal.Name == "" {
+		principal.Name = username
+	}
+	return principal, nil
+}
+
+func (b *BasicAuthenticator) Challenge(realm string) string {
+	return fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, realm)
+}
+
+// "api-key" scheme, the key is read from the header, then from the query if configured
+type APIKeyAuthenticator struct {
+	Provider APIKeyProvider
+
+	// eg: X-API-Key, empty to ignore headers
+	Header string
+	// eg: api_key, empty to ignore the query
+	Query string
+}
+
+func (a *APIKeyAuthenticator) Scheme() string {
+	return "api-key"
+}
+
+func (a *APIKeyAuthenticator) Authenticate(ctx *RequestCtx) (*Principal, error) {
+	var key string
+	if a.Header != "" {
+		key = ctx.Request.Header.Get(a.Header)
+	}
+	if key == "" && a.Query != "" {
+		key = ctx.Request.URL.Query().Get(a.Query)
+	}
+	if key == "" {
+		return nil, nil
+	}
+	principal, e := a.Provider.LookupAPIKey(key)
+	if e != nil {
+		return nil, e
+	}
+	if principal == nil {
+		return nil, &ErrInvalidCredentials{Reason: "invalid api key"}
+	}
+	return principal, nil
+}
+
+// API keys have no standard challenge
+func (a *APIKeyAuthenticator) Challenge(realm string) string {
+	return ""
+}
+
+// the Authorization header is of the scheme, case insensitive
+func hasAuthorization(ctx *RequestCtx, scheme string) bool {
+	auth := ctx.Request.Header.Get("Authorization")
+	return len(auth) > len(scheme) && strings.EqualFold(auth[:len(scheme)], scheme) && auth[len(scheme)] == ' '
+}
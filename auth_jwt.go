@@ -0,0 +1,331 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// verification key of JWT
+type JWTKey struct {
+	// kid of the tokens it verifies, empty verifies any token
+	ID string
+
+	// []byte for HS256, *rsa.PublicKey for RS256, *ecdsa.PublicKey of P-256 for ES256
+	Key interface{}
+}
+
+// "jwt" scheme, bearer tokens signed by HS256, RS256 or ES256
+// the algorithm must match the type of the key, tokens of other algorithms are rejected
+type JWTAuthenticator struct {
+	Keys []JWTKey
+
+	// checked if not empty
+	Issuer   string
+	Audience string
+
+	// seconds of clock skew tolerated by exp and nbf
+	Leeway int
+
+	// claim of the principal roles, a list or a space separated string, eg: "roles" or "scope"
+	RolesClaim string
+}
+
+func (j *JWTAuthenticator) Scheme() string {
+	return "jwt"
+}
+
+func (j *JWTAuthenticator) Authenticate(ctx *RequestCtx) (*Principal, error) {
+	if !hasAuthorization(ctx, "Bearer") {
+		return nil, nil
+	}
+	token := strings.TrimSpace(ctx.Request.Header.Get("Authorization")[len("Bearer "):])
+	claims, e := j.Verify(token)
+	if e != nil {
+		return nil, &ErrInvalidCredentials{Reason: e.Error()}
+	}
+	principal := &Principal{Claims: claims}
+	principal.Name, _ = claims["sub"].(string)
+	principal.Roles = claimList(claims[j.RolesClaim])
+	return principal, nil
+}
+
+func (j *JWTAuthenticator) Challenge(realm string) string {
+	return fmt.Sprintf(`Bearer realm=%q`, realm)
+}
+
+// claims of the token if its signature and time, issuer and audience claims are valid
+// numbers of the claims are json.Number
+func (j *JWTAuthenticator) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if e := decodeSegment(parts[0], &header); e != nil {
+		return nil, errors.New("malformed token header")
+	}
+	signature, e := base64.RawURLEncoding.DecodeString(parts[2])
+	if e != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	if !j.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], signature) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	claims := map[string]interface{}{}
+	if e := decodeSegment(parts[1], &claims); e != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	if e := j.verifyClaims(claims); e != nil {
+		return nil, e
+	}
+	return claims, nil
+}
+
+// any key of the kid accepting the algorithm verifies the signature
+func (j *JWTAuthenticator) verifySignature(alg string, kid string, signed string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(signed))
+	for _, k := range j.Keys {
+		if k.ID != "" && k.ID != kid {
+			continue
+		}
+		switch key := k.Key.(type) {
+		case []byte:
+			if alg == "HS256" {
+				mac := hmac.New(sha256.New, key)
+				mac.Write([]byte(signed))
+				if hmac.Equal(mac.Sum(nil), signature) {
+					return true
+				}
+			}
+		case *rsa.PublicKey:
+			if alg == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			// the signature is r and s of 32 bytes each
+			if alg == "ES256" && key.Curve == elliptic.P256() && len(signature) == 64 {
+				r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+				if ecdsa.Verify(key, digest[:], r, s) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (j *JWTAuthenticator) verifyClaims(claims map[string]interface{}) error {
+	now := time.Now().Unix()
+	leeway := int64(j.Leeway)
+	exp, ok, e := claimTime(claims, "exp")
+	if e != nil {
+		return e
+	}
+	if ok && now > exp+leeway {
+		return errors.New("token is expired")
+	}
+	nbf, ok, e := claimTime(claims, "nbf")
+	if e != nil {
+		return e
+	}
+	if ok && now < nbf-leeway {
+		return errors.New("token is not valid yet")
+	}
+	if j.Issuer != "" && claims["iss"] != j.Issuer {
+		return errors.New("invalid token issuer")
+	}
+	if j.Audience != "" {
+		found := false
+		for _, aud := range claimList(claims["aud"]) {
+			found = found || aud == j.Audience
+		}
+		if !found {
+			return errors.New("invalid token audience")
+		}
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, e := base64.RawURLEncoding.DecodeString(segment)
+	if e != nil {
+		return e
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// seconds since epoch of a time claim, false if absent
+// a claim which is not a number is an error, so it never passes as absent
+func claimTime(claims map[string]interface{}, name string) (int64, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return 0, false, nil
+	}
+	if n, ok := v.(json.Number); ok {
+		if i, e := n.Int64(); e == nil {
+			return i, true, nil
+		}
+		if f, e := n.Float64(); e == nil {
+			return int64(f), true, nil
+		}
+	}
+	return 0, false, fmt.Errorf("invalid token claim %s", name)
+}
+
+// strings of a list claim, or the words of a string claim
+func claimList(v interface{}) []string {
+	switch claim := v.(type) {
+	case string:
+		return strings.Fields(claim)
+	case []interface{}:
+		var list []string
+		for _, item := range claim {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// keys of the secret, PEM files and JWKS file, empty ones are ignored
+// the secret and keys of PEM files verify any token, keys of the JWKS file verify tokens of their kid
+func LoadJWTKeys(secret string, pemFiles []string, jwksFile string) ([]JWTKey, error) {
+	var keys []JWTKey
+	if secret != "" {
+		keys = append(keys, JWTKey{Key: []byte(secret)})
+	}
+	for _, file := range pemFiles {
+		data, e := ioutil.ReadFile(filepath.Clean(file))
+		if e != nil {
+			return nil, e
+		}
+		key, e := parsePEMKey(data)
+		if e != nil {
+			return nil, fmt.Errorf("%s: %v", file, e)
+		}
+		keys = append(keys, JWTKey{Key: key})
+	}
+	if jwksFile != "" {
+		data, e := ioutil.ReadFile(filepath.Clean(jwksFile))
+		if e != nil {
+			return nil, e
+		}
+		jwks, e := parseJWKS(data)
+		if e != nil {
+			return nil, fmt.Errorf("%s: %v", jwksFile, e)
+		}
+		keys = append(keys, jwks...)
+	}
+	return keys, nil
+}
+
+// public key of the first PEM block, a PKIX or PKCS#1 public key, or a certificate
+func parsePEMKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, e := x509.ParseCertificate(block.Bytes)
+		if e != nil {
+			return nil, e
+		}
+		return cert.PublicKey, nil
+	}
+	return nil, fmt.Errorf("unsupported PEM block %s", block.Type)
+}
+
+// RSA, P-256 and symmetric keys of the set, keys for encryption are ignored
+func parseJWKS(data []byte) ([]JWTKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if e := json.Unmarshal(data, &set); e != nil {
+		return nil, e
+	}
+	var keys []JWTKey
+	for _, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		var key interface{}
+		switch k.Kty {
+		case "RSA":
+			n, e1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, e2 := base64.RawURLEncoding.DecodeString(k.E)
+			if e1 != nil || e2 != nil || len(e) > 4 {
+				return nil, fmt.Errorf("invalid RSA key %s", k.Kid)
+			}
+			key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				return nil, fmt.Errorf("unsupported curve %s of key %s", k.Crv, k.Kid)
+			}
+			x, e1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, e2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if e1 != nil || e2 != nil {
+				return nil, fmt.Errorf("invalid EC key %s", k.Kid)
+			}
+			pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+				return nil, fmt.Errorf("invalid EC key %s", k.Kid)
+			}
+			key = pub
+		case "oct":
+			secret, e := base64.RawURLEncoding.DecodeString(k.K)
+			if e != nil {
+				return nil, fmt.Errorf("invalid symmetric key %s", k.Kid)
+			}
+			key = secret
+		default:
+			return nil, fmt.Errorf("unsupported key type %s of key %s", k.Kty, k.Kid)
+		}
+		keys = append(keys, JWTKey{ID: k.Kid, Key: key})
+	}
+	return keys, nil
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	testSecret = []byte("secret")
+	testRSAKey *rsa.PrivateKey
+	testECKey  *ecdsa.PrivateKey
+)
+
+func init() {
+	var e error
+	if testRSAKey, e = rsa.GenerateKey(rand.Reader, 2048); e != nil {
+		panic(e)
+	}
+	if testECKey, e = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); e != nil {
+		panic(e)
+	}
+}
+
+// token of the header and claims signed by the key, a *rsa.PrivateKey, *ecdsa.PrivateKey, []byte or nil
+func signToken(header map[string]interface{}, claims map[string]interface{}, key interface{}) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+		// r and s padded to 32 bytes each
+		signature = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rb):32], rb)
+		copy(signature[64-len(sb):], sb)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func alg(name string) map[string]interface{} {
+	return map[string]interface{}{"alg": name, "typ": "JWT"}
+}
+
+func TestJWTVerify(t *testing.T) {
+	now := time.Now().Unix()
+	rsaPublic, _ := x509.MarshalPKIXPublicKey(&testRSAKey.PublicKey)
+	keys := []JWTKey{{Key: testSecret}, {Key: &testRSAKey.PublicKey}, {Key: &testECKey.PublicKey}}
+	sub := map[string]interface{}{"sub": "u"}
+
+	for _, c := range []struct {
+		name  string
+		j     JWTAuthenticator
+		token string
+		err   string
+	}{
+		{"HS256", JWTAuthenticator{Keys: keys}, signToken(alg("HS256"), sub, testSecret), ""},
+		{"RS256", JWTAuthenticator{Keys: keys}, signToken(alg("RS256"), sub, testRSAKey), ""},
+		{"ES256", JWTAuthenticator{Keys: keys}, signToken(alg("ES256"), sub, testECKey), ""},
+		{"HS256 other secret", JWTAuthenticator{Keys: keys}, signToken(alg("HS256"), sub, []byte("other")), "invalid token signature"},
+		{"RS256 other key", JWTAuthenticator{Keys: keys}, signToken(alg("RS256"), sub, mustRSAKey()), "invalid token signature"},
+		{"ES256 truncated", JWTAuthenticator{Keys: keys}, truncate(signToken(alg("ES256"), sub, testECKey), 4), "invalid token signature"},
+
+		// the algorithm of the header must match the type of the key
+		{"HS256 signed by RSA public key", JWTAuthenticator{Keys: []JWTKey{{Key: &testRSAKey.PublicKey}}}, signToken(alg("HS256"), sub, rsaPublic), "invalid token signature"},
+		{"RS256 header of ES256", JWTAuthenticator{Keys: keys}, signToken(alg("RS256"), sub, testECKey), "invalid token signature"},
+		{"ES256 header of HS256", JWTAuthenticator{Keys: keys}, signToken(alg("ES256"), sub, testSecret), "invalid token signature"},
+		{"none", JWTAuthenticator{Keys: keys}, signToken(alg("none"), sub, nil), "invalid token signature"},
+		{"none signed", JWTAuthenticator{Keys: keys}, signToken(alg("none"), sub, testSecret), "invalid token signature"},
+		{"malformed", JWTAuthenticator{Keys: keys}, "a.b", "malformed token"},
+
+		// exp and nbf with leeway
+		{"exp", JWTAuthenticator{Keys: keys}, signToken(alg("HS256"), map[string]interface{}{"exp": now + 60}, testSecret), ""},
+		{"expired", JWTAuthenticator{Keys: keys}, signToken(alg("HS256"), map[string]interface{}{"exp": now - 10}, testSecret), "token is expired"},
+		{"expired within leeway", JWTAuthenticator{Keys: keys, Leeway: 30}, signToken(alg("HS256"), map[string]interface{}{"exp": now - 10}, testSecret), ""},
+		{"expired beyond leeway", JWTAuthenticator{Keys: keys, Leeway: 30}, signToken(alg("HS256"), map[string]interface{}{"exp": now - 60}, testSecret), "token is expired"},
+		{"exp float", JWTAuthenticator{Keys: keys}, signToken(alg("HS256"), map[string]interface{}{"exp": float64(now) + 60.5}, testSecret), ""},
+		{"exp string", JWTAuthenticator{Keys: keys}, signToken(alg("HS256"), map[string]interface{}{"exp": "1"}, testSecret), "invalid token claim exp"},
+		{"exp null", JWTAuthenticator{Keys: keys}, signToken(alg("HS256"), map[string]interface{}{"exp": nil}, testSecret), "invalid token claim exp"},
+		{"nbf", JWTAuthenticator{Keys: keys}, signToken(alg("HS256"), map[string]interface{}{"nbf": now - 10}, testSecret), ""},
+		{"not valid yet", JWTAuthenticator{Keys: keys}, signToken(alg("HS256"), map[string]interface{}{"nbf": now + 60}, testSecret), "token is not valid yet"},
+		{"nbf within leeway", JWTAuthenticator{Keys: keys, Leeway: 30}, signToken(alg("HS256"), map[string]interface{}{"nbf": now + 10}, testSecret), ""},
+		{"nbf string", JWTAuthenticator{Keys: keys}, signToken(alg("HS256"), map[string]interface{}{"nbf": "x"}, testSecret), "invalid token claim nbf"},
+
+		// iss and aud
+		{"iss", JWTAuthenticator{Keys: keys, Issuer: "https://auth"}, signToken(alg("HS256"), map[string]interface{}{"iss": "https://auth"}, testSecret), ""},
+		{"other iss", JWTAuthenticator{Keys: keys, Issuer: "https://auth"}, signToken(alg("HS256"), map[string]interface{}{"iss": "https://evil"}, testSecret), "invalid token issuer"},
+		{"no iss", JWTAuthenticator{Keys: keys, Issuer: "https://auth"}, signToken(alg("HS256"), sub, testSecret), "invalid token issuer"},
+		{"aud", JWTAuthenticator{Keys: keys, Audience: "api"}, signToken(alg("HS256"), map[string]interface{}{"aud": "api"}, testSecret), ""},
+		{"aud list", JWTAuthenticator{Keys: keys, Audience: "api"}, signToken(alg("HS256"), map[string]interface{}{"aud": []string{"web", "api"}}, testSecret), ""},
+		{"other aud", JWTAuthenticator{Keys: keys, Audience: "api"}, signToken(alg("HS256"), map[string]interface{}{"aud": []string{"web"}}, testSecret), "invalid token audience"},
+		{"no aud", JWTAuthenticator{Keys: keys, Audience: "api"}, signToken(alg("HS256"), sub, testSecret), "invalid token audience"},
+	} {
+		_, e := c.j.Verify(c.token)
+		if actual := fmt.Sprint(e); (c.err == "" && e != nil) || (c.err != "" && actual != c.err) {
+			t.Errorf("%s: %v, expected %q", c.name, e, c.err)
+		}
+	}
+}
+
+func TestJWTKeyID(t *testing.T) {
+	j := JWTAuthenticator{Keys: []JWTKey{
+		{ID: "a", Key: []byte("secret a")},
+		{ID: "b", Key: []byte("secret b")},
+		{ID: "rsa", Key: &testRSAKey.PublicKey},
+	}}
+	kid := func(alg string, kid string) map[string]interface{} {
+		return map[string]interface{}{"alg": alg, "kid": kid}
+	}
+	for _, c := range []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"kid a", signToken(kid("HS256", "a"), nil, []byte("secret a")), true},
+		{"kid b", signToken(kid("HS256", "b"), nil, []byte("secret b")), true},
+		{"kid rsa", signToken(kid("RS256", "rsa"), nil, testRSAKey), true},
+		{"kid a signed by b", signToken(kid("HS256", "a"), nil, []byte("secret b")), false},
+		{"kid b of rsa", signToken(kid("RS256", "b"), nil, testRSAKey), false},
+		{"unknown kid", signToken(kid("HS256", "c"), nil, []byte("secret a")), false},
+		{"no kid", signToken(alg("HS256"), nil, []byte("secret a")), false},
+	} {
+		if _, e := j.Verify(c.token); (e == nil) != c.valid {
+			t.Errorf("%s: %v", c.name, e)
+		}
+	}
+
+	// keys without kid verify tokens of any kid
+	j = JWTAuthenticator{Keys: []JWTKey{{Key: testSecret}}}
+	if _, e := j.Verify(signToken(kid("HS256", "x"), nil, testSecret)); e != nil {
+		t.Errorf("key without kid: %v", e)
+	}
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	j := &JWTAuthenticator{Keys: []JWTKey{{Key: testSecret}}, RolesClaim: "scope"}
+	ctx := &RequestCtx{Request: httptest.NewRequest("GET", "/", nil)}
+	if p, e := j.Authenticate(ctx); p != nil || e != nil {
+		t.Errorf("no token: %v %v", p, e)
+	}
+
+	token := signToken(alg("HS256"), map[string]interface{}{"sub": "u", "scope": "read write"}, testSecret)
+	ctx.Request.Header.Set("Authorization", "Bearer "+token)
+	p, e := j.Authenticate(ctx)
+	if e != nil || p.Name != "u" || !reflect.DeepEqual(p.Roles, []string{"read", "write"}) {
+		t.Errorf("token: %+v %v", p, e)
+	}
+
+	ctx.Request.Header.Set("Authorization", "Bearer "+token+"x")
+	if p, e := j.Authenticate(ctx); p != nil || e == nil {
+		t.Errorf("invalid token: %v %v", p, e)
+	} else if _, ok := e.(*ErrInvalidCredentials); !ok {
+		t.Errorf("invalid token is not rejected as invalid credentials: %T", e)
+	}
+}
+
+func TestParseJWTKeys(t *testing.T) {
+	der, _ := x509.MarshalPKIXPublicKey(&testECKey.PublicKey)
+	key, e := parsePEMKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if e != nil || !reflect.DeepEqual(key, &testECKey.PublicKey) {
+		t.Errorf("PEM: %v", e)
+	}
+
+	b64 := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": b64(testRSAKey.N.Bytes()), "e": b64(big.NewInt(int64(testRSAKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(testECKey.X.Bytes()), "y": b64(testECKey.Y.Bytes())},
+		{"kty": "oct", "kid": "hs", "k": b64(testSecret)},
+		{"kty": "RSA", "kid": "enc", "use": "enc"},
+	}})
+	keys, e := parseJWKS(jwks)
+	if e != nil || len(keys) != 3 {
+		t.Fatalf("JWKS: %v %v", keys, e)
+	}
+	j := JWTAuthenticator{Keys: keys}
+	for _, token := range []string{
+		signToken(map[string]interface{}{"alg": "RS256", "kid": "rsa"}, nil, testRSAKey),
+		signToken(map[string]interface{}{"alg": "ES256", "kid": "ec"}, nil, testECKey),
+		signToken(map[string]interface{}{"alg": "HS256", "kid": "hs"}, nil, testSecret),
+	} {
+		if _, e := j.Verify(token); e != nil {
+			t.Errorf("JWKS key: %v", e)
+		}
+	}
+
+	if _, e := parseJWKS([]byte(`{"keys":[{"kty":"EC","kid":"p384","crv":"P-384"}]}`)); e == nil {
+		t.Error("P-384 key is accepted")
+	}
+}
+
+func truncate(s string, n int) string {
+	return s[:len(s)-n]
+}
+
+func mustRSAKey() *rsa.PrivateKey {
+	key, e := rsa.GenerateKey(rand.Reader, 2048)
+	if e != nil {
+		panic(e)
+	}
+	return key
+}
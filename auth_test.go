@@ -0,0 +1,139 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// "alice:secret" is valid, "db" fails as if the store was unreachable
+type testCredentials struct{}
+
+func (testCredentials) VerifyCredentials(username string, password string) (*Principal, error) {
+	switch {
+	case username == "db":
+		return nil, errors.New("dial tcp 10.0.0.1:5432: connection refused")
+	case username == "alice" && password == "secret":
+		return &Principal{Roles: []string{"admin"}}, nil
+	}
+	return nil, nil
+}
+
+type testAPIKeys struct{}
+
+func (testAPIKeys) LookupAPIKey(key string) (*Principal, error) {
+	if key == "k1" {
+		return &Principal{Name: "service"}, nil
+	}
+	return nil, nil
+}
+
+func authServer(required bool) *HttpServer {
+	s := newTestServer()
+	s.AddInterceptor(&authInterceptor{realm: "test", required: required, authenticators: []Authenticator{
+		&BasicAuthenticator{Provider: testCredentials{}},
+		&APIKeyAuthenticator{Provider: testAPIKeys{}, Header: "X-API-Key"},
+	}})
+	whoami := func(ctx *RequestCtx) interface{} {
+		if p := ctx.Principal(); p != nil {
+			return p.Scheme + ":" + p.Name
+		}
+		return "anonymous"
+	}
+	s.GET("/public", whoami)
+	s.GET("/open", whoami, NewProperty(AuthProperty, false))
+	s.GET("/private", whoami, NewProperty(AuthProperty, true))
+	s.GET("/hooks", whoami, NewProperty(AuthProperty, "api-key"))
+	return s
+}
+
+func basic(username, password string) http.Header {
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.SetBasicAuth(username, password)
+	return r.Header
+}
+
+func TestAuthentication(t *testing.T) {
+	s := authServer(false)
+	for _, c := range []struct {
+		url    string
+		header http.Header
+		status int
+		body   string
+	}{
+		{"/public", nil, 200, "anonymous"},
+		{"/public", basic("alice", "secret"), 200, "basic:alice"},
+		{"/public", http.Header{"X-Api-Key": {"k1"}}, 200, "api-key:service"},
+		{"/open", basic("alice", "wrong"), 200, "anonymous"},
+		{"/private", basic("alice", "secret"), 200, "basic:alice"},
+		{"/private", nil, 401, "authentication required"},
+		// credentials sent to routes not requiring them are still verified
+		{"/public", basic("alice", "wrong"), 401, "invalid credentials"},
+		{"/public", http.Header{"X-Api-Key": {"k2"}}, 401, "invalid api key"},
+		{"/public", http.Header{"Authorization": {"Basic !"}}, 401, "malformed basic credentials"},
+		// only the schemes of the route are accepted
+		{"/hooks", basic("alice", "secret"), 401, "authentication required"},
+		{"/hooks", http.Header{"X-Api-Key": {"k1"}}, 200, "api-key:service"},
+	} {
+		w := serve(s, "GET", c.url, c.header)
+		if w.Code != c.status || !strings.Contains(w.Body.String(), c.body) {
+			t.Errorf("%s %v: %d %q, expected %d %q", c.url, c.header, w.Code, w.Body.String(), c.status, c.body)
+		}
+	}
+
+	w := serve(s, "GET", "/private", nil)
+	if challenges := w.Header()["Www-Authenticate"]; !reflect.DeepEqual(challenges, []string{`Basic realm="test", charset="UTF-8"`}) {
+		t.Errorf("challenges: %v", challenges)
+	}
+	if w := serve(authServer(true), "GET", "/public", nil); w.Code != 401 {
+		t.Errorf("required by default: %d", w.Code)
+	}
+}
+
+func TestAuthenticatorFailure(t *testing.T) {
+	s := authServer(false)
+	w := serve(s, "GET", "/public", basic("db", "secret"))
+	if w.Code != http.StatusInternalServerError || w.Header().Get("WWW-Authenticate") != "" {
+		t.Errorf("%d %v", w.Code, w.Header())
+	}
+	// the cause is logged, not responded
+	if body := w.Body.String(); !strings.Contains(body, "authentication failed") || strings.Contains(body, "10.0.0.1") {
+		t.Errorf("%q", body)
+	}
+}
+
+func TestAdminRoutesAuth(t *testing.T) {
+	for _, c := range []struct {
+		auth     string
+		property interface{}
+		status   int
+	}{
+		{"true", true, 401},
+		{"basic, jwt", []string{"basic", "jwt"}, 401},
+		{"false", false, 200},
+		{"", nil, 200},
+	} {
+		s := authServer(false)
+		s.AdminConfig = &AdminConfig{EnableRoutes: true, RoutesPath: "/admin/routes", RoutesAuth: c.auth}
+		s.mapAdminEndpoints()
+
+		node := matchRoute(s, Get, "/admin/routes", map[string]string{})
+		if property := node.properties[AuthProperty]; !reflect.DeepEqual(property, c.property) {
+			t.Errorf("%q: %#v, expected %#v", c.auth, property, c.property)
+		}
+		if w := serve(s, "GET", "/admin/routes", nil); w.Code != c.status {
+			t.Errorf("%q: %d, expected %d", c.auth, w.Code, c.status)
+		}
+		if w := serve(s, "GET", "/admin/routes", basic("alice", "secret")); w.Code != 200 {
+			t.Errorf("%q authenticated: %d", c.auth, w.Code)
+		}
+	}
+}
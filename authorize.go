@@ -0,0 +1,223 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"fmt"
+	"github.com/kinoko-projects/kinoko"
+	"sync"
+)
+
+// property key of the roles allowed to access a route, a string or a []string, any of them is required
+//
+//	eg: s.DELETE("/users/:id", handler, NewProperty(RolesProperty, []string{"admin"}))
+const RolesProperty = "roles"
+
+// property key of an expression allowing to access a route, checked after the roles
+// hasRole('r'), hasAnyRole('r1', 'r2') and isAuthenticated() are built in, other functions are PermissionEvaluator permissions
+// arguments are quoted strings, words, or path variables of the route, eg: :id
+//
+//	eg: s.PUT("/posts/:id", handler, NewProperty(AuthorizeProperty, "hasRole('admin') || owner(:id)"))
+//
+// routes having roles or an expression require authentication unless AuthProperty is set
+const AuthorizeProperty = "authorize"
+
+// domain checks of authorize expressions, register it as a kinoko spore
+//
+//	eg: func (o *PostOwner) Permissions() []string { return []string{"owner"} }
+//		func (o *PostOwner) Evaluate(ctx *RequestCtx, permission string, args []string) (bool, error) {
+//			post, e := o.posts.Find(args[0])
+//			return e == nil && post.Author == ctx.Principal().Name, e
+//		}
+type PermissionEvaluator interface {
+	// function names of expressions, eg: "owner"
+	Permissions() []string
+
+	// args are resolved, errors are responded instead of 403, eg: a failed query
+	Evaluate(ctx *RequestCtx, permission string, args []string) (bool, error)
+}
+
+// arguments of built-in functions, -1 for any number
+var accessBuiltins = map[string]int{
+	"hasRole":         1,
+	"hasAnyRole":      -1,
+	"isAuthenticated": 0,
+}
+
+// global interceptor checking roles and authorize expressions of routes, 403 is responded if denied
+// it is named "authorize" and called after "auth"
+type authorizeInterceptor struct {
+	evaluators map[string]PermissionEvaluator
+
+	// parsed expressions by source
+	expressions sync.Map
+}
+
+func newAuthorizeInterceptor(evaluators []PermissionEvaluator) (*authorizeInterceptor, error) {
+	a := &authorizeInterceptor{evaluators: map[string]PermissionEvaluator{}}
+	for _, evaluator := range evaluators {
+		for _, permission := range evaluator.Permissions() {
+			if _, ok := accessBuiltins[permission]; ok {
+				return nil, fmt.Errorf("permission %s is built in", permission)
+			}
+			if other, ok := a.evaluators[permission]; ok {
+				return nil, fmt.Errorf("permission %s is evaluated by %T and %T", permission, other, evaluator)
+			}
+			a.evaluators[permission] = evaluator
+		}
+	}
+	return a, nil
+}
+
+func (a *authorizeInterceptor) Priority() int {
+	return -900
+}
+
+func (a *authorizeInterceptor) Name() string {
+	return "authorize"
+}
+
+func (a *authorizeInterceptor) Before() []string {
+	return nil
+}
+
+func (a *authorizeInterceptor) After() []string {
+	return []string{"auth"}
+}
+
+func (a *authorizeInterceptor) Intercept(ctx *RequestCtx, properties map[string]interface{}) (InterceptorAction, interface{}) {
+	roles, guarded := properties[RolesProperty]
+	source, hasExpression := properties[AuthorizeProperty].(string)
+	if !guarded && !hasExpression {
+		return Continue, nil
+	}
+	principal := ctx.Principal()
+	if principal == nil {
+		return Block, Forbidden("access denied").WithCode("FORBIDDEN")
+	}
+	if guarded && !hasAnyRole(principal, accessRoles(roles)) {
+		return Block, Forbidden("access denied").WithCode("FORBIDDEN")
+	}
+	if hasExpression {
+		expr, e := a.parse(source)
+		if e != nil {
+			logger.Error(e)
+			return Block, Forbidden("access denied").WithCode("FORBIDDEN")
+		}
+		allowed, e := expr.eval(ctx, func(name string, args []string) (bool, error) {
+			return a.call(ctx, name, args)
+		})
+		if e != nil {
+			return Block, e
+		}
+		if !allowed {
+			return Block, Forbidden("access denied").WithCode("FORBIDDEN")
+		}
+	}
+	return Continue, nil
+}
+
+func (a *authorizeInterceptor) parse(source string) (accessExpr, error) {
+	if expr, ok := a.expressions.Load(source); ok {
+		return expr.(accessExpr), nil
+	}
+	expr, e := parseAccess(source)
+	if e != nil {
+		return nil, e
+	}
+	if e := a.check(expr); e != nil {
+		return nil, e
+	}
+	a.expressions.Store(source, expr)
+	return expr, nil
+}
+
+// functions of the expression are built in or evaluated
+func (a *authorizeInterceptor) check(expr accessExpr) error {
+	var err error
+	expr.functions(func(name string, args int) {
+		if n, ok := accessBuiltins[name]; ok {
+			if n >= 0 && n != args && err == nil {
+				err = fmt.Errorf("%s expects %d arguments", name, n)
+			}
+		} else if a.evaluators[name] == nil && err == nil {
+			err = fmt.Errorf("permission %s is not evaluated by any PermissionEvaluator", name)
+		}
+	})
+	return err
+}
+
+func (a *authorizeInterceptor) call(ctx *RequestCtx, name string, args []string) (bool, error) {
+	principal := ctx.Principal()
+	switch name {
+	case "hasRole", "hasAnyRole":
+		return principal != nil && hasAnyRole(principal, args), nil
+	case "isAuthenticated":
+		return principal != nil, nil
+	}
+	return a.evaluators[name].Evaluate(ctx, name, args)
+}
+
+// expressions of every route are parsed and checked, failing the initialization
+func (a *authorizeInterceptor) validate(routes []RouteInfo) error {
+	for _, r := range routes {
+		if source, ok := r.Properties[AuthorizeProperty].(string); ok {
+			if _, e := a.parse(source); e != nil {
+				return fmt.Errorf("%s %s: %v", r.Method, r.Pattern, e)
+			}
+		}
+	}
+	return nil
+}
+
+// roles and authorize properties are checked once the route is mapped
+func checkAccessProperties(properties []HandlerProperties) error {
+	switch roles := propertyValue(properties, RolesProperty).(type) {
+	case nil, string, []string:
+	default:
+		return fmt.Errorf("roles must be a string or a []string, not %T", roles)
+	}
+	switch source := propertyValue(properties, AuthorizeProperty).(type) {
+	case nil:
+	case string:
+		_, e := parseAccess(source)
+		return e
+	default:
+		return fmt.Errorf("authorize must be a string, not %T", source)
+	}
+	return nil
+}
+
+func accessRoles(v interface{}) []string {
+	switch roles := v.(type) {
+	case string:
+		return []string{roles}
+	case []string:
+		return roles
+	}
+	return nil
+}
+
+func hasAnyRole(principal *Principal, roles []string) bool {
+	for _, role := range roles {
+		if principal.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionEvaluator spores, sorted by name
+func permissionEvaluators() []PermissionEvaluator {
+	spores := kinoko.Application.GetImplementedSpores((*PermissionEvaluator)(nil))
+	sortSpores(spores)
+	evaluators := make([]PermissionEvaluator, 0, len(spores))
+	for _, spore := range spores {
+		evaluators = append(evaluators, spore.(PermissionEvaluator))
+	}
+	return evaluators
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"net/http"
+	"testing"
+)
+
+// principal of the X-User header, with the roles of X-Roles
+type headerAuthenticator struct {
+}
+
+func (a *headerAuthenticator) Scheme() string {
+	return "header"
+}
+
+func (a *headerAuthenticator) Authenticate(ctx *RequestCtx) (*Principal, error) {
+	user := ctx.Request.Header.Get("X-User")
+	if user == "" {
+		return nil, nil
+	}
+	return &Principal{Name: user, Roles: splitList(ctx.Request.Header.Get("X-Roles"))}, nil
+}
+
+func (a *headerAuthenticator) Challenge(realm string) string {
+	return ""
+}
+
+type actionInterceptor struct {
+	priority int
+	action   InterceptorAction
+	called   int
+}
+
+func (i *actionInterceptor) Priority() int {
+	return i.priority
+}
+
+func (i *actionInterceptor) Intercept(ctx *RequestCtx, properties map[string]interface{}) (InterceptorAction, interface{}) {
+	i.called++
+	if i.action == Block {
+		return Block, Status(http.StatusTeapot, "blocked")
+	}
+	return i.action, nil
+}
+
+// interceptors before the access control skip every interceptor but it
+func TestSkipDoesNotBypassAccessControl(t *testing.T) {
+	s := newTestServer()
+	s.GET("/admin", text("admin"), NewProperty(RolesProperty, "admin"))
+	s.GET("/owner/:id", text("owner"), NewProperty(AuthorizeProperty, "hasRole('owner')"))
+	s.GET("/public", text("public"))
+
+	chain := &s.handlers.interceptorChain
+	skipper := &actionInterceptor{priority: -5000, action: Skip}
+	blocker := &actionInterceptor{priority: 0, action: Block}
+	chain.AddInterceptor(skipper)
+	chain.addGuard(&authInterceptor{realm: "test", authenticators: []Authenticator{&headerAuthenticator{}}})
+	authorize, _ := newAuthorizeInterceptor(nil)
+	chain.addGuard(authorize)
+	chain.AddInterceptor(blocker)
+	s.reselectInterceptors(routeScope{}, chain)
+
+	for _, c := range []struct {
+		url    string
+		header http.Header
+		code   int
+	}{
+		{"/admin", nil, http.StatusUnauthorized},
+		{"/admin", http.Header{"X-User": {"u"}}, http.StatusForbidden},
+		{"/admin", http.Header{"X-User": {"u"}, "X-Roles": {"admin"}}, http.StatusOK},
+		{"/owner/1", nil, http.StatusUnauthorized},
+		{"/owner/1", http.Header{"X-User": {"u"}, "X-Roles": {"admin"}}, http.StatusForbidden},
+		{"/owner/1", http.Header{"X-User": {"u"}, "X-Roles": {"owner"}}, http.StatusOK},
+		{"/public", nil, http.StatusOK},
+	} {
+		if w := serve(s, "GET", c.url, c.header); w.Code != c.code {
+			t.Errorf("GET %s %v: %d, expected %d", c.url, c.header, w.Code, c.code)
+		}
+	}
+	if skipper.called != 7 || blocker.called != 0 {
+		t.Errorf("skipper called %d times, blocker %d times", skipper.called, blocker.called)
+	}
+
+	// the chain without interceptor stops at the blocker once access is granted
+	skipper.action = Continue
+	if w := serve(s, "GET", "/public", nil); w.Code != http.StatusTeapot {
+		t.Errorf("GET /public without skip: %d", w.Code)
+	}
+	if w := serve(s, "GET", "/admin", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /admin without skip: %d", w.Code)
+	}
+}
+
+func TestCallInterceptorsSkip(t *testing.T) {
+	chain := NewInterceptorChain()
+	chain.AddInterceptor(&actionInterceptor{priority: -5000, action: Skip})
+	authorize, _ := newAuthorizeInterceptor(nil)
+	chain.addGuard(authorize)
+
+	ctx := &RequestCtx{}
+	if blocked, _ := chain.CallInterceptors(ctx, map[string]interface{}{RolesProperty: "admin"}); !blocked {
+		t.Error("skip bypassed authorization")
+	}
+	if blocked, _ := chain.CallInterceptors(ctx, map[string]interface{}{}); blocked {
+		t.Error("unguarded route blocked")
+	}
+}
+
+func TestAdminRoutesRoles(t *testing.T) {
+	s := newTestServer()
+	s.AdminConfig = &AdminConfig{EnableRoutes: true, RoutesPath: "/admin/routes", RoutesAuth: "header", RoutesRoles: "admin, ops"}
+	s.mapAdminEndpoints()
+
+	chain := &s.handlers.interceptorChain
+	chain.addGuard(&authInterceptor{realm: "test", authenticators: []Authenticator{&headerAuthenticator{}}})
+	authorize, _ := newAuthorizeInterceptor(nil)
+	if e := authorize.validate(s.Routes()); e != nil {
+		t.Fatal(e)
+	}
+	chain.addGuard(authorize)
+	s.reselectInterceptors(routeScope{}, chain)
+
+	for _, c := range []struct {
+		header http.Header
+		code   int
+	}{
+		{nil, http.StatusUnauthorized},
+		{http.Header{"X-User": {"u"}}, http.StatusForbidden},
+		{http.Header{"X-User": {"u"}, "X-Roles": {"ops"}}, http.StatusOK},
+		{http.Header{"X-User": {"u"}, "X-Roles": {"admin"}}, http.StatusOK},
+	} {
+		if w := serve(s, "GET", "/admin/routes", c.header); w.Code != c.code {
+			t.Errorf("%v: %d, expected %d", c.header, w.Code, c.code)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// a field which failed to be bound or validated
+type FieldError struct {
+	Field string `json:"field"`
+
+	// where the value comes from: path, query, header or body
+	Source string `json:"source,omitempty"`
+
+	// validation rule which failed, empty if the value can not be bound
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+}
+
+// returned by typed handlers when the request can not be bound or validated, responded as 400
+type BindingError struct {
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors"`
+}
+
+func (e *BindingError) Error() string {
+	details := make([]string, len(e.Errors))
+	for i, f := range e.Errors {
+		details[i] = f.Field + ": " + f.Message
+	}
+	return e.Message + " - " + strings.Join(details, "; ")
+}
+
+var (
+	requestCtxType = reflect.TypeOf((*RequestCtx)(nil))
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// bind a parameter from the request, returns a *BindingError listing the invalid fields
+// or any other error which fails the request
+type paramBinder func(ctx *RequestCtx) (reflect.Value, error)
+
+// Typed handler binding, parameters of fn are resolved by their types:
+//
+//	*RequestCtx				the request context
+//	string, int, bool...	path variables, in the order they appear in the pattern
+//	struct					fields tagged with `path:"id"`, `query:"page"` or `header:"X-Token"`
+//	*struct					request body, parsed by RequestCtx.ParseBody
+//
+// fn returns nothing, a value, an error, or a value and an error
+//
+//	eg: func(ctx *RequestCtx, id int64, q SearchQuery, body *CreateReq) (*Resp, error)
+//
+// reflection is done once here, fn is verified at mapping time and panics if not supported
+func bindHandler(pattern string, fn interface{}) RequestHandlerFunc {
+	if h, ok := fn.(func(ctx *RequestCtx) interface{}); ok {
+		return h
+	}
+	if h, ok := fn.(RequestHandlerFunc); ok {
+		return h
+	}
+
+	fnValue := reflect.ValueOf(fn)
+	t := fnValue.Type()
+	if t.Kind() != reflect.Func {
+		panic(fmt.Sprintf("%v is not a function", t))
+	}
+
+	placeholders := patternPlaceholders(pattern)
+	binders := make([]paramBinder, t.NumIn())
+	for i := 0; i < t.NumIn(); i++ {
+		in := t.In(i)
+		switch {
+		case in == requestCtxType:
+			binders[i] = func(ctx *RequestCtx) (reflect.Value, error) {
+				return reflect.ValueOf(ctx), nil
+			}
+		case in.Kind() == reflect.Struct:
+			binders[i] = structBinder(in)
+		case in.Kind() == reflect.Ptr && in.Elem().Kind() == reflect.Struct:
+			binders[i] = bodyBinder(in)
+		case isScalar(in):
+			if len(placeholders) == 0 {
+				panic(fmt.Sprintf("parameter %d of %v has no path variable to bind in %s", i, t, pattern))
+			}
+			binders[i] = pathBinder(placeholders[0], in)
+			placeholders = placeholders[1:]
+		default:
+			panic(fmt.Sprintf("unsupported parameter %d of %v", i, t))
+		}
+	}
+
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	switch {
+	case t.NumOut() > 2, t.NumOut() == 2 && !returnsError:
+		panic(fmt.Sprintf("unsupported return values of %v", t))
+	}
+
+	return func(ctx *RequestCtx) interface{} {
+		in := make([]reflect.Value, len(binders))
+		var errs []FieldError
+		for i, binder := range binders {
+			v, e := binder(ctx)
+			if invalid, ok := e.(*BindingError); ok {
+				errs = append(errs, invalid.Errors...)
+			} else if e != nil {
+				return e
+			}
+			in[i] = v
+		}
+		if len(errs) > 0 {
+			return &BindingError{Message: "invalid request", Errors: errs}
+		}
+
+		out := fnValue.Call(in)
+		if returnsError {
+			if e := out[len(out)-1]; !e.IsNil() {
+				return e.Interface()
+			}
+			out = out[:len(out)-1]
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		if k := out[0].Kind(); (k == reflect.Ptr || k == reflect.Interface) && out[0].IsNil() {
+			return nil
+		}
+		return out[0].Interface()
+	}
+}
+
+func pathBinder(name string, t reflect.Type) paramBinder {
+	return func(ctx *RequestCtx) (reflect.Value, error) {
+		pv, ok := ctx.PathVariable[name]
+		if !ok {
+			return reflect.Zero(t), nil //optional segment
+		}
+		v, e := convertValues([]string{pv}, t)
+		if e != nil {
+			return v, invalidFields(FieldError{Field: name, Source: "path", Message: e.Error()})
+		}
+		return v, nil
+	}
+}
+
+func invalidFields(errs ...FieldError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &BindingError{Message: "invalid request", Errors: errs}
+}
+
+func bodyBinder(t reflect.Type) paramBinder {
+	if e := checkValidation(t, map[reflect.Type]bool{}); e != nil {
+		panic(e.Error())
+	}
+	return func(ctx *RequestCtx) (reflect.Value, error) {
+		v := reflect.New(t.Elem())
+		// an empty body is validated as the zero value, its required fields are reported
+		if ctx.Request.Body == nil || ctx.Request.ContentLength == 0 {
+			var errs []FieldError
+			validateValue(v, "", "body", &errs)
+			return v, invalidFields(errs...)
+		}
+		e := ctx.ParseBody(v.Interface())
+		switch err := e.(type) {
+		case nil:
+		case *BindingError:
+			for i := range err.Errors {
+				err.Errors[i].Source = "body"
+			}
+			return v, err
+		case *ErrUnsupportedMediaType:
+			return v, err
+		default:
+			return v, invalidFields(FieldError{Field: "body", Source: "body", Message: e.Error()})
+		}
+		return v, nil
+	}
+}
+
+type fieldBinder struct {
+	index  int
+	source string
+	name   string
+	t      reflect.Type
+}
+
+// resolve the tagged fields once, unexported and untagged fields are ignored
+func structBinder(t reflect.Type) paramBinder {
+	if e := checkValidation(t, map[reflect.Type]bool{}); e != nil {
+		panic(e.Error())
+	}
+	var fields []fieldBinder
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		for _, source := range []string{"path", "query", "header"} {
+			if name, ok := f.Tag.Lookup(source); ok {
+				if !isScalar(f.Type) && !(f.Type.Kind() == reflect.Slice && isScalar(f.Type.Elem())) {
+					panic(fmt.Sprintf("unsupported field %s of %v", f.Name, t))
+				}
+				if source == "header" {
+					name = http.CanonicalHeaderKey(name)
+				}
+				fields = append(fields, fieldBinder{index: i, source: source, name: name, t: f.Type})
+				break
+			}
+		}
+	}
+
+	return func(ctx *RequestCtx) (reflect.Value, error) {
+		v := reflect.New(t).Elem()
+		var errs []FieldError
+		for _, f := range fields {
+			var values []string
+			switch f.source {
+			case "path":
+				if pv, ok := ctx.PathVariable[f.name]; ok {
+					values = []string{pv}
+				}
+			case "query":
+				values = ctx.QueryString[f.name]
+			case "header":
+				values = ctx.Request.Header[f.name]
+			}
+			if len(values) == 0 {
+				continue
+			}
+			fv, e := convertValues(values, f.t)
+			if e != nil {
+				errs = append(errs, FieldError{Field: f.name, Source: f.source, Message: e.Error()})
+				continue
+			}
+			v.Field(f.index).Set(fv)
+		}
+		if len(errs) == 0 {
+			validateValue(v, "", "", &errs)
+		}
+		return v, invalidFields(errs...)
+	}
+}
+
+func isScalar(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// convert the raw values to t, a slice takes every value and a scalar takes the first one
+func convertValues(values []string, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(t, len(values), len(values))
+		for i, s := range values {
+			v, e := convertValues([]string{s}, t.Elem())
+			if e != nil {
+				return slice, e
+			}
+			slice.Index(i).Set(v)
+		}
+		return slice, nil
+	}
+	if t.Kind() == reflect.Ptr {
+		v, e := convertValues(values, t.Elem())
+		if e != nil {
+			return reflect.Zero(t), e
+		}
+		ptr := reflect.New(t.Elem())
+		ptr.Elem().Set(v)
+		return ptr, nil
+	}
+
+	s := ""
+	if len(values) > 0 {
+		s = values[0]
+	}
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, e := strconv.ParseBool(s)
+		if e != nil {
+			return v, fmt.Errorf("'%s' is not a boolean", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, e := strconv.ParseInt(s, 10, t.Bits())
+		if e != nil {
+			return v, fmt.Errorf("'%s' is not a valid %v", s, t)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, e := strconv.ParseUint(s, 10, t.Bits())
+		if e != nil {
+			return v, fmt.Errorf("'%s' is not a valid %v", s, t)
+		}
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, e := strconv.ParseFloat(s, t.Bits())
+		if e != nil {
+			return v, fmt.Errorf("'%s' is not a valid %v", s, t)
+		}
+		v.SetFloat(f)
+	default:
+		return v, fmt.Errorf("unsupported type %v", t)
+	}
+	return v, nil
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type searchQuery struct {
+	ID    int64    `path:"id"`
+	Page  int      `query:"page"`
+	Tags  []string `query:"tag"`
+	Token *string  `header:"x-token"`
+	Debug bool     `query:"debug"`
+}
+
+type createReq struct {
+	Name string `json:"name"`
+}
+
+func TestBindParameters(t *testing.T) {
+	s := newTestServer()
+	s.Bind(Get, "/users/:id/posts/:slug", func(id int64, slug string, q searchQuery) string {
+		return fmt.Sprint(id, " ", slug, " ", q.ID, " ", q.Page, " ", q.Tags, " ", *q.Token, " ", q.Debug)
+	})
+
+	w := serve(s, "GET", "/users/7/posts/hello?page=2&tag=a&tag=b&debug=true", http.Header{"X-Token": {"secret"}})
+	if expected := "7 hello 7 2 [a b] secret true"; w.Body.String() != expected {
+		t.Errorf("%d %q, expected %q", w.Code, w.Body.String(), expected)
+	}
+}
+
+func TestBindConversionErrors(t *testing.T) {
+	s := newTestServer()
+	s.Bind(Get, "/users/:id", func(id int64, q searchQuery) string {
+		return "bound"
+	})
+
+	w := serve(s, "GET", "/users/abc?page=x&tag=a&debug=maybe", http.Header{"X-Token": {"secret"}})
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("%d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var e struct {
+		Detail  string       `json:"detail"`
+		Code    string       `json:"code"`
+		Details []FieldError `json:"details"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	expected := []FieldError{
+		{Field: "id", Source: "path", Message: "'abc' is not a valid int64"},
+		{Field: "id", Source: "path", Message: "'abc' is not a valid int64"},
+		{Field: "page", Source: "query", Message: "'x' is not a valid int"},
+		{Field: "debug", Source: "query", Message: "'maybe' is not a boolean"},
+	}
+	if e.Detail != "invalid request" || e.Code != "INVALID_REQUEST" || !reflect.DeepEqual(e.Details, expected) {
+		t.Errorf("%s", w.Body.String())
+	}
+}
+
+func TestBindHeaderConversionError(t *testing.T) {
+	s := newTestServer()
+	s.Bind(Get, "/", func(q struct {
+		Count uint8 `header:"X-Count"`
+	}) string {
+		return "bound"
+	})
+
+	w := serve(s, "GET", "/", http.Header{"X-Count": {"300"}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `{"field":"X-Count","source":"header","message":"'300' is not a valid uint8"}`) {
+		t.Errorf("%d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestBindBody(t *testing.T) {
+	s := newTestServer()
+	s.Bind(Post, "/users", func(body *createReq) (string, error) {
+		return "created " + body.Name, nil
+	})
+
+	r := httptest.NewRequest("POST", "/users", strings.NewReader(`{"name":"kinoko"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.handlers.ServeHTTP(w, r)
+	if w.Body.String() != "created kinoko" {
+		t.Errorf("%d %q", w.Code, w.Body.String())
+	}
+
+	r = httptest.NewRequest("POST", "/users", strings.NewReader(`{"name":`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	s.handlers.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"field":"body","source":"body"`) {
+		t.Errorf("malformed body: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestBindUnsupportedParameters(t *testing.T) {
+	for name, fn := range map[string]interface{}{
+		"no path variable": func(id int) {},
+		"map parameter":    func(m map[string]string) {},
+		"return values":    func() (string, string) { return "", "" },
+		"not a function":   "handler",
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: not rejected", name)
+				}
+			}()
+			bindHandler("/", fn)
+		}()
+	}
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// property key overriding the cors policy of a route,
+// the value is a *CORSPolicy, or false to disable cors for the route
+//
+//	eg: s.GET("/public", handler, NewProperty(CORSProperty, &CORSPolicy{AllowedOrigins: []string{"*"}}))
+const CORSProperty = "cors"
+
+// CORS configuration sample, lists are comma separated
+//
+//	kinoko:
+//	  web:
+//	    cors:
+//	      enable: true
+//	      allowed-origins: https://example.com, https://*.example.com, ^https://[a-z]+\.example\.org$
+//	      allowed-methods: GET, POST
+//	      allowed-headers: Content-Type, Authorization
+//	      exposed-headers: Location
+//	      allow-credentials: true
+//	      max-age: 3600
+type CORSConfig struct {
+	Enable           bool   `inject:"kinoko.web.cors.enable:false"`
+	AllowedOrigins   string `inject:"kinoko.web.cors.allowed-origins:*"`
+	AllowedMethods   string `inject:"kinoko.web.cors.allowed-methods:"`
+	AllowedHeaders   string `inject:"kinoko.web.cors.allowed-headers:"`
+	ExposedHeaders   string `inject:"kinoko.web.cors.exposed-headers:"`
+	AllowCredentials bool   `inject:"kinoko.web.cors.allow-credentials:false"`
+	MaxAge           int    `inject:"kinoko.web.cors.max-age:0"`
+}
+
+// the policy built from config, nil if cors is disabled
+// credentials are dropped if any origin is allowed, as every site could send credentialed requests
+func (c *CORSConfig) Policy() *CORSPolicy {
+	if !c.Enable {
+		return nil
+	}
+	origins := splitList(c.AllowedOrigins)
+	credentials := c.AllowCredentials
+	if credentials && containsFold(origins, "*") {
+		logger.Error("CORS credentials can not be allowed for any origin, allow-credentials is ignored")
+		credentials = false
+	}
+	return &CORSPolicy{
+		AllowedOrigins:   origins,
+		AllowedMethods:   splitList(c.AllowedMethods),
+		AllowedHeaders:   splitList(c.AllowedHeaders),
+		ExposedHeaders:   splitList(c.ExposedHeaders),
+		AllowCredentials: credentials,
+		MaxAge:           c.MaxAge,
+	}
+}
+
+type CORSPolicy struct {
+	// "*" allows any origin, "https://*.example.com" is a wildcard, entries starting with '^' are regular expressions
+	// credentials are never allowed if any origin is
+	AllowedOrigins []string
+
+	// methods allowed by preflight, empty allows the methods mapped for the path
+	AllowedMethods []string
+
+	// headers allowed by preflight, empty or "*" allows any requested header
+	AllowedHeaders []string
+
+	ExposedHeaders   []string
+	AllowCredentials bool
+
+	// seconds the preflight can be cached, 0 to omit
+	MaxAge int
+
+	once      sync.Once
+	anyOrigin bool
+	origins   []*regexp.Regexp
+}
+
+func (p *CORSPolicy) compile() {
+	for _, o := range p.AllowedOrigins {
+		switch {
+		case o == "*":
+			p.anyOrigin = true
+		case strings.HasPrefix(o, "^"):
+			r, e := regexp.Compile(o)
+			if e != nil {
+				logger.Error("Invalid cors origin", o, e)
+				continue
+			}
+			p.origins = append(p.origins, r)
+		default:
+			quoted := strings.Replace(regexp.QuoteMeta(strings.ToLower(o)), "\\*", "[^/]*", -1)
+			p.origins = append(p.origins, regexp.MustCompile("^"+quoted+"$"))
+		}
+	}
+	if p.anyOrigin && p.AllowCredentials {
+		logger.Error("CORS credentials can not be allowed for any origin, credentials are not allowed")
+	}
+}
+
+func (p *CORSPolicy) allowOrigin(origin string) bool {
+	p.once.Do(p.compile)
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, r := range p.origins {
+		if r.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// write the origin related headers shared by preflight and actual requests
+// the origin is never echoed if any origin is allowed, so credentials are not sent
+func (p *CORSPolicy) writeOrigin(wr http.ResponseWriter, origin string) {
+	h := wr.Header()
+	if p.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Add("Vary", "Origin")
+	h.Set("Access-Control-Allow-Origin", origin)
+	if p.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// the cors policy of the route, nil if disabled
+func (c *RequestHandler) corsPolicy(node *prefixNode) *CORSPolicy {
+	switch p := node.properties[CORSProperty].(type) {
+	case *CORSPolicy:
+		return p
+	case bool:
+		if !p {
+			return nil
+		}
+	}
+	return c.cors
+}
+
+// answer the preflight request from the route of the requested method
+// returns false if cors is not enabled for the route and the request should be handled normally
+func (c *RequestHandler) preflight(wr http.ResponseWriter, r *http.Request, table routeTable, url string, fold bool) bool {
+	requested := RequestMethod(r.Header.Get("Access-Control-Request-Method"))
+	node, _ := table.lookup(requested, url, map[string]string{}, fold)
+	if node == nil {
+		return false
+	}
+	policy := c.corsPolicy(node)
+	if policy == nil {
+		return false
+	}
+
+	h := wr.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	origin := r.Header.Get("Origin")
+	if !policy.allowOrigin(origin) {
+		h.Add("Vary", "Origin")
+		wr.WriteHeader(http.StatusForbidden)
+		return true
+	}
+	if len(policy.AllowedMethods) > 0 && !containsFold(policy.AllowedMethods, string(requested)) {
+		h.Add("Vary", "Origin")
+		wr.WriteHeader(http.StatusForbidden)
+		return true
+	}
+
+	policy.writeOrigin(wr, origin)
+	if len(policy.AllowedMethods) > 0 {
+		h.Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+	} else {
+		h.Set("Access-Control-Allow-Methods", string(requested))
+	}
+	if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		if len(policy.AllowedHeaders) == 0 || containsFold(policy.AllowedHeaders, "*") {
+			h.Set("Access-Control-Allow-Headers", headers)
+		} else {
+			h.Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+		}
+	}
+	if policy.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+	}
+	wr.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// cors headers of an actual request
+func (c *RequestHandler) corsHeaders(wr http.ResponseWriter, r *http.Request, node *prefixNode) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	policy := c.corsPolicy(node)
+	if policy == nil {
+		return
+	}
+	if !policy.allowOrigin(origin) {
+		wr.Header().Add("Vary", "Origin")
+		return
+	}
+	policy.writeOrigin(wr, origin)
+	if len(policy.ExposedHeaders) > 0 {
+		wr.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+	}
+}
+
+// "a, b,,c" => [a b c]
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCORSAnyOriginWithoutCredentials(t *testing.T) {
+	config := &CORSConfig{Enable: true, AllowedOrigins: "*", AllowCredentials: true}
+	policy := config.Policy()
+	if policy.AllowCredentials {
+		t.Error("credentials allowed for any origin")
+	}
+
+	for name, policy := range map[string]*CORSPolicy{
+		"config":   policy,
+		"property": {AllowedOrigins: []string{"*"}, AllowCredentials: true},
+	} {
+		s := newTestServer()
+		s.handlers.cors = policy
+		s.GET("/a", text("a"))
+
+		header := http.Header{"Origin": {"https://evil.com"}, "Access-Control-Request-Method": {"GET"}}
+		w := serve(s, "OPTIONS", "/a", header)
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%s preflight: %d", name, w.Code)
+		}
+		if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
+			t.Errorf("%s preflight: Access-Control-Allow-Origin %q", name, origin)
+		}
+		if credentials := w.Header().Get("Access-Control-Allow-Credentials"); credentials != "" {
+			t.Errorf("%s preflight: Access-Control-Allow-Credentials %q", name, credentials)
+		}
+
+		w = serve(s, "GET", "/a", http.Header{"Origin": {"https://evil.com"}})
+		if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Errorf("%s actual request: %v", name, w.Header())
+		}
+	}
+}
+
+func TestCORSCredentialsEchoOrigin(t *testing.T) {
+	s := newTestServer()
+	s.handlers.cors = (&CORSConfig{Enable: true, AllowedOrigins: "https://*.example.com", AllowCredentials: true}).Policy()
+	s.GET("/a", text("a"))
+
+	w := serve(s, "GET", "/a", http.Header{"Origin": {"https://app.example.com"}})
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("allowed origin: %v", w.Header())
+	}
+	w = serve(s, "GET", "/a", http.Header{"Origin": {"https://evil.com"}})
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("denied origin: %v", w.Header())
+	}
+}
@@ -0,0 +1,225 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// decoder of request bodies used by RequestCtx.ParseBody, register it as a kinoko spore
+// a decoder replaces the registered one of the same media type
+type BodyDecoder interface {
+	// media types handled, eg: application/json
+	MediaTypes() []string
+	Decode(ctx *RequestCtx, dst interface{}) error
+}
+
+// returned by RequestCtx.ParseBody if the content type has no decoder, responded as 415
+type ErrUnsupportedMediaType struct {
+	MediaType string
+}
+
+func (e *ErrUnsupportedMediaType) Error() string {
+	return fmt.Sprintf("content-type: '%s' is not supported", e.MediaType)
+}
+
+var bodyDecoders = struct {
+	sync.RWMutex
+	decoders map[string]BodyDecoder
+}{decoders: map[string]BodyDecoder{}}
+
+func init() {
+	for _, d := range []BodyDecoder{
+		new(JSONDecoder), new(FormDecoder), new(XMLDecoder),
+		&MultipartDecoder{MaxMemory: 32 << 20}, new(TextDecoder), new(RawDecoder),
+	} {
+		RegisterBodyDecoder(d)
+	}
+}
+
+func RegisterBodyDecoder(decoder BodyDecoder) {
+	bodyDecoders.Lock()
+	for _, t := range decoder.MediaTypes() {
+		bodyDecoders.decoders[strings.ToLower(t)] = decoder
+	}
+	bodyDecoders.Unlock()
+}
+
+func bodyDecoder(mediaType string) BodyDecoder {
+	bodyDecoders.RLock()
+	defer bodyDecoders.RUnlock()
+	return bodyDecoders.decoders[mediaType]
+}
+
+type JSONDecoder struct {
+}
+
+func (*JSONDecoder) MediaTypes() []string {
+	return []string{"application/json"}
+}
+
+func (*JSONDecoder) Decode(ctx *RequestCtx, dst interface{}) error {
+	bytes, e := ioutil.ReadAll(ctx.Request.Body) //Note that ReadAll is not safe for oom attack
+	if e != nil {
+		return e
+	}
+	return json.Unmarshal(bytes, dst)
+}
+
+// decode url encoded form into the struct fields tagged with `form:"name"`, like MultipartDecoder
+type FormDecoder struct {
+}
+
+func (*FormDecoder) MediaTypes() []string {
+	return []string{"application/x-www-form-urlencoded"}
+}
+
+func (*FormDecoder) Decode(ctx *RequestCtx, dst interface{}) error {
+	bytes, e := ioutil.ReadAll(ctx.Request.Body)
+	if e != nil {
+		return e
+	}
+
+	values, e := url.ParseQuery(string(bytes))
+	if e != nil {
+		return e
+	}
+	return decodeForm(values, nil, dst)
+}
+
+type XMLDecoder struct {
+}
+
+func (*XMLDecoder) MediaTypes() []string {
+	return []string{"application/xml", "text/xml"}
+}
+
+func (*XMLDecoder) Decode(ctx *RequestCtx, dst interface{}) error {
+	return xml.NewDecoder(ctx.Request.Body).Decode(dst)
+}
+
+// decode multipart form into the struct fields tagged with `form:"name"`
+// files are mapped onto *multipart.FileHeader or []*multipart.FileHeader fields
+// the parsed form is kept in RequestCtx.MultipartForm
+type MultipartDecoder struct {
+	// bytes of the files stored in memory, the rest is stored on disk
+	MaxMemory int64
+}
+
+func (*MultipartDecoder) MediaTypes() []string {
+	return []string{"multipart/form-data"}
+}
+
+var (
+	fileHeaderType      = reflect.TypeOf((*multipart.FileHeader)(nil))
+	fileHeaderSliceType = reflect.TypeOf([]*multipart.FileHeader(nil))
+)
+
+func (d *MultipartDecoder) Decode(ctx *RequestCtx, dst interface{}) error {
+	if ctx.MultipartForm == nil {
+		if e := ctx.Request.ParseMultipartForm(d.MaxMemory); e != nil {
+			return e
+		}
+		ctx.MultipartForm = ctx.Request.MultipartForm
+	}
+	return decodeForm(ctx.MultipartForm.Value, ctx.MultipartForm.File, dst)
+}
+
+// set the fields tagged with `form:"name"` to the converted values, files are set to file header fields
+func decodeForm(values map[string][]string, files map[string][]*multipart.FileHeader, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("form can not be decoded into %T", dst)
+	}
+	v = v.Elem()
+	t := v.Type()
+
+	var errs []FieldError
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, ok := f.Tag.Lookup("form")
+		if !ok || f.PkgPath != "" {
+			continue
+		}
+		switch f.Type {
+		case fileHeaderType:
+			if fs := files[name]; len(fs) > 0 {
+				v.Field(i).Set(reflect.ValueOf(fs[0]))
+			}
+		case fileHeaderSliceType:
+			v.Field(i).Set(reflect.ValueOf(files[name]))
+		default:
+			vs := values[name]
+			if len(vs) == 0 {
+				continue
+			}
+			fv, e := convertValues(vs, f.Type)
+			if e != nil {
+				errs = append(errs, FieldError{Field: name, Source: "body", Message: e.Error()})
+				continue
+			}
+			v.Field(i).Set(fv)
+		}
+	}
+	if len(errs) > 0 {
+		return &BindingError{Message: "invalid request", Errors: errs}
+	}
+	return nil
+}
+
+// decode text into *string or *[]byte
+type TextDecoder struct {
+}
+
+func (*TextDecoder) MediaTypes() []string {
+	return []string{"text/plain"}
+}
+
+func (*TextDecoder) Decode(ctx *RequestCtx, dst interface{}) error {
+	return readRaw(ctx.Request.Body, dst)
+}
+
+// decode raw bytes into *[]byte, *string or io.Writer
+type RawDecoder struct {
+}
+
+func (*RawDecoder) MediaTypes() []string {
+	return []string{"application/octet-stream"}
+}
+
+func (*RawDecoder) Decode(ctx *RequestCtx, dst interface{}) error {
+	return readRaw(ctx.Request.Body, dst)
+}
+
+func readRaw(body io.Reader, dst interface{}) error {
+	if w, ok := dst.(io.Writer); ok {
+		_, e := io.Copy(w, body)
+		return e
+	}
+	bytes, e := ioutil.ReadAll(body)
+	if e != nil {
+		return e
+	}
+	switch d := dst.(type) {
+	case *[]byte:
+		*d = bytes
+	case *string:
+		*d = string(bytes)
+	default:
+		return fmt.Errorf("raw body can not be decoded into %T", dst)
+	}
+	return nil
+}
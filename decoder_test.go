@@ -0,0 +1,150 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type profileForm struct {
+	Name   string                `form:"name" json:"name" xml:"name"`
+	Age    int                   `form:"age" json:"age" xml:"age"`
+	Tags   []string              `form:"tag" json:"tags" xml:"tag"`
+	Avatar *multipart.FileHeader `form:"avatar" json:"-" xml:"-"`
+}
+
+func postBody(s *HttpServer, contentType string, body io.Reader) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/profile", body)
+	r.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	s.handlers.ServeHTTP(w, r)
+	return w
+}
+
+func profileServer() *HttpServer {
+	s := newTestServer()
+	s.Bind(Post, "/profile", func(body *profileForm) string {
+		avatar := ""
+		if body.Avatar != nil {
+			avatar = body.Avatar.Filename
+		}
+		return fmt.Sprint(body.Name, " ", body.Age, " ", body.Tags, " ", avatar)
+	})
+	return s
+}
+
+func TestBodyDecoders(t *testing.T) {
+	s := profileServer()
+
+	multipartBody := &bytes.Buffer{}
+	mw := multipart.NewWriter(multipartBody)
+	_ = mw.WriteField("name", "kinoko")
+	_ = mw.WriteField("age", "3")
+	_ = mw.WriteField("tag", "a")
+	_ = mw.WriteField("tag", "b")
+	fw, _ := mw.CreateFormFile("avatar", "avatar.png")
+	_, _ = fw.Write([]byte("png"))
+	_ = mw.Close()
+
+	for _, c := range []struct {
+		contentType string
+		body        io.Reader
+		expected    string
+	}{
+		{"application/json", strings.NewReader(`{"name":"kinoko","age":3,"tags":["a","b"]}`), "kinoko 3 [a b] "},
+		{"application/json; charset=utf-8", strings.NewReader(`{"name":"kinoko"}`), "kinoko 0 [] "},
+		{"application/x-www-form-urlencoded", strings.NewReader("name=kinoko&age=3&tag=a&tag=b"), "kinoko 3 [a b] "},
+		{"Application/X-WWW-Form-Urlencoded", strings.NewReader("name=kinoko"), "kinoko 0 [] "},
+		{"text/xml", strings.NewReader("<profileForm><name>kinoko</name><age>3</age><tag>a</tag><tag>b</tag></profileForm>"), "kinoko 3 [a b] "},
+		{mw.FormDataContentType(), multipartBody, "kinoko 3 [a b] avatar.png"},
+	} {
+		if w := postBody(s, c.contentType, c.body); w.Body.String() != c.expected {
+			t.Errorf("%s: %d %q, expected %q", c.contentType, w.Code, w.Body.String(), c.expected)
+		}
+	}
+}
+
+func TestFormDecoderConversionError(t *testing.T) {
+	w := postBody(profileServer(), "application/x-www-form-urlencoded", strings.NewReader("name=kinoko&age=old"))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `{"field":"age","source":"body","message":"'old' is not a valid int"}`) {
+		t.Errorf("%d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestUnsupportedMediaType(t *testing.T) {
+	w := postBody(profileServer(), "application/yaml", strings.NewReader("name: kinoko"))
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("%d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRawDecoders(t *testing.T) {
+	s := newTestServer()
+	s.POST("/text", func(ctx *RequestCtx) interface{} {
+		var text string
+		if e := ctx.ParseBody(&text); e != nil {
+			return e
+		}
+		return "text " + text
+	})
+	s.POST("/raw", func(ctx *RequestCtx) interface{} {
+		buf := &bytes.Buffer{}
+		if e := ctx.ParseBody(buf); e != nil {
+			return e
+		}
+		return fmt.Sprint("raw ", buf.Len())
+	})
+
+	r := httptest.NewRequest("POST", "/text", strings.NewReader("hello"))
+	r.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	w := httptest.NewRecorder()
+	s.handlers.ServeHTTP(w, r)
+	if w.Body.String() != "text hello" {
+		t.Errorf("text: %q", w.Body.String())
+	}
+
+	r = httptest.NewRequest("POST", "/raw", bytes.NewReader([]byte{1, 2, 3}))
+	r.Header.Set("Content-Type", "application/octet-stream")
+	w = httptest.NewRecorder()
+	s.handlers.ServeHTTP(w, r)
+	if w.Body.String() != "raw 3" {
+		t.Errorf("raw: %q", w.Body.String())
+	}
+}
+
+// decodes "name age" lines
+type lineDecoder struct{}
+
+func (lineDecoder) MediaTypes() []string {
+	return []string{"text/x-profile"}
+}
+
+func (lineDecoder) Decode(ctx *RequestCtx, dst interface{}) error {
+	p := dst.(*profileForm)
+	_, e := fmt.Fscan(ctx.Request.Body, &p.Name, &p.Age)
+	return e
+}
+
+func TestRegisterBodyDecoder(t *testing.T) {
+	RegisterBodyDecoder(lineDecoder{})
+	defer func() {
+		bodyDecoders.Lock()
+		delete(bodyDecoders.decoders, "text/x-profile")
+		bodyDecoders.Unlock()
+	}()
+
+	if w := postBody(profileServer(), "text/x-profile", strings.NewReader("kinoko 3")); w.Body.String() != "kinoko 3 [] " {
+		t.Errorf("%d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,351 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// property key pinning the response format of a route, the value is a media type
+// the Accept header is ignored for the route
+//
+//	eg: s.GET("/export", handler, NewProperty(ProducesProperty, "text/csv"))
+const ProducesProperty = "produces"
+
+// encoder of response values negotiated by the Accept header, register it as a kinoko spore
+// an encoder replaces the registered one of the same media type
+type ResponseEncoder interface {
+	// media types produced, the first one is preferred
+	MediaTypes() []string
+
+	// tells if the value can be encoded, eg: csv only supports slices of structs
+	Supports(v interface{}) bool
+	Encode(w io.Writer, v interface{}) error
+}
+
+// registered encoders in order, the first one is used if the client accepts anything
+var responseEncoders = struct {
+	sync.RWMutex
+	encoders []ResponseEncoder
+}{}
+
+func init() {
+	for _, e := range []ResponseEncoder{
+		new(JSONEncoder), new(XMLEncoder), new(YAMLEncoder), new(CSVEncoder), new(MsgpackEncoder),
+	} {
+		RegisterResponseEncoder(e)
+	}
+}
+
+func RegisterResponseEncoder(encoder ResponseEncoder) {
+	responseEncoders.Lock()
+	defer responseEncoders.Unlock()
+	for i, e := range responseEncoders.encoders {
+		for _, t := range e.MediaTypes() {
+			if containsFold(encoder.MediaTypes(), t) {
+				responseEncoders.encoders[i] = encoder
+				return
+			}
+		}
+	}
+	responseEncoders.encoders = append(responseEncoders.encoders, encoder)
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parse the Accept header, ranges are sorted by quality and then specificity
+// unacceptable ranges (q=0) are kept last to exclude the media types they match, see accepts
+//
+//	eg: "text/*;q=0.5, application/json, text/csv;q=0" => [application/json text/* text/csv]
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, r := range strings.Split(accept, ",") {
+		params := strings.Split(r, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+		if mediaType == "*" {
+			mediaType = "*/*"
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if f, e := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); e == nil {
+					q = f
+				}
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return strings.Count(ranges[i].mediaType, "*") < strings.Count(ranges[j].mediaType, "*")
+	})
+	return ranges
+}
+
+// "text/*" matches "text/csv"
+func (r acceptRange) match(mediaType string) bool {
+	if r.mediaType == "*/*" || r.mediaType == mediaType {
+		return true
+	}
+	if strings.HasSuffix(r.mediaType, "/*") {
+		return strings.HasPrefix(mediaType, r.mediaType[:len(r.mediaType)-1])
+	}
+	return false
+}
+
+// the range matches the media type, and the most specific range of ranges matching it is acceptable
+//
+//	eg: */* of "application/json;q=0, */*" does not accept application/json
+func (r acceptRange) accepts(ranges []acceptRange, mediaType string) bool {
+	if r.q <= 0 || !r.match(mediaType) {
+		return false
+	}
+	specific := r
+	for _, other := range ranges {
+		if other.match(mediaType) && strings.Count(other.mediaType, "*") < strings.Count(specific.mediaType, "*") {
+			specific = other
+		}
+	}
+	return specific.q > 0
+}
+
+// pick the encoder of the most acceptable media type supporting v, returns nil if nothing is acceptable
+func negotiateEncoder(accept string, v interface{}) (ResponseEncoder, string) {
+	responseEncoders.RLock()
+	defer responseEncoders.RUnlock()
+
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		ranges = []acceptRange{{mediaType: "*/*", q: 1}}
+	}
+	for _, r := range ranges {
+		for _, e := range responseEncoders.encoders {
+			if !e.Supports(v) {
+				continue
+			}
+			for _, t := range e.MediaTypes() {
+				if r.accepts(ranges, t) {
+					return e, t
+				}
+			}
+		}
+	}
+	return nil, ""
+}
+
+// the encoder of the media type pinned by ProducesProperty
+func pinnedEncoder(mediaType string) ResponseEncoder {
+	responseEncoders.RLock()
+	defer responseEncoders.RUnlock()
+	for _, e := range responseEncoders.encoders {
+		if containsFold(e.MediaTypes(), mediaType) {
+			return e
+		}
+	}
+	return nil
+}
+
+// encode v negotiated by the request, responds 406 if nothing is acceptable
+// returns the IO error of writing the response
+func writeNegotiated(ctx *RequestCtx, wr http.ResponseWriter, v interface{}) error {
+	var encoder ResponseEncoder
+	var mediaType string
+	if pinned, ok := ctx.properties[ProducesProperty].(string); ok {
+		encoder, mediaType = pinnedEncoder(pinned), pinned
+		if encoder == nil {
+			http.Error(wr, fmt.Sprintf("no encoder is registered for %s", pinned), 500)
+			return nil
+		}
+		if !encoder.Supports(v) {
+			http.Error(wr, fmt.Sprintf("%T can not be encoded as %s", v, pinned), 500)
+			return nil
+		}
+	} else {
+		wr.Header().Add("Vary", "Accept")
+		encoder, mediaType = negotiateEncoder(ctx.Request.Header.Get("Accept"), v)
+		if encoder == nil {
+			renderError(wr, ctx.Request, NewHTTPError(http.StatusNotAcceptable, "no acceptable representation"))
+			return nil
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if e := encoder.Encode(buf, v); e != nil {
+		http.Error(wr, e.Error(), 500)
+		return nil
+	}
+	wr.Header().Set("Content-Type", mediaType)
+	_, e := buf.WriteTo(wr)
+	return e
+}
+
+type JSONEncoder struct {
+}
+
+func (*JSONEncoder) MediaTypes() []string {
+	return []string{"application/json"}
+}
+
+func (*JSONEncoder) Supports(v interface{}) bool {
+	return true
+}
+
+func (*JSONEncoder) Encode(w io.Writer, v interface{}) error {
+	bytes, e := json.Marshal(v)
+	if e != nil {
+		return e
+	}
+	_, e = w.Write(bytes)
+	return e
+}
+
+type XMLEncoder struct {
+}
+
+func (*XMLEncoder) MediaTypes() []string {
+	return []string{"application/xml", "text/xml"}
+}
+
+// maps are not supported by encoding/xml
+func (*XMLEncoder) Supports(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t != nil && t.Kind() != reflect.Map
+}
+
+func (*XMLEncoder) Encode(w io.Writer, v interface{}) error {
+	if _, e := io.WriteString(w, xml.Header); e != nil {
+		return e
+	}
+	return xml.NewEncoder(w).Encode(v)
+}
+
+type YAMLEncoder struct {
+}
+
+func (*YAMLEncoder) MediaTypes() []string {
+	return []string{"application/yaml", "application/x-yaml", "text/yaml"}
+}
+
+func (*YAMLEncoder) Supports(v interface{}) bool {
+	return true
+}
+
+func (*YAMLEncoder) Encode(w io.Writer, v interface{}) error {
+	bytes, e := yaml.Marshal(v)
+	if e != nil {
+		return e
+	}
+	_, e = w.Write(bytes)
+	return e
+}
+
+// encode slices of structs, the header is named by `csv` or `json` tags
+type CSVEncoder struct {
+}
+
+func (*CSVEncoder) MediaTypes() []string {
+	return []string{"text/csv"}
+}
+
+func (*CSVEncoder) Supports(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	if t == nil || (t.Kind() != reflect.Slice && t.Kind() != reflect.Array) {
+		return false
+	}
+	t = t.Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+func (c *CSVEncoder) Encode(w io.Writer, v interface{}) error {
+	if !c.Supports(v) {
+		return fmt.Errorf("%T can not be encoded as csv", v)
+	}
+	rows := reflect.ValueOf(v)
+	t := rows.Type().Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var header []string
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		for _, key := range []string{"csv", "json"} {
+			if n := strings.Split(f.Tag.Get(key), ",")[0]; n != "" {
+				name = n
+				break
+			}
+		}
+		if name == "-" {
+			continue
+		}
+		header = append(header, name)
+		fields = append(fields, i)
+	}
+
+	cw := csv.NewWriter(w)
+	if e := cw.Write(header); e != nil {
+		return e
+	}
+	record := make([]string, len(fields))
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i)
+		for row.Kind() == reflect.Ptr {
+			row = row.Elem()
+		}
+		if !row.IsValid() {
+			continue
+		}
+		for j, index := range fields {
+			f := row.Field(index)
+			for f.Kind() == reflect.Ptr && !f.IsNil() {
+				f = f.Elem()
+			}
+			if f.Kind() == reflect.Ptr {
+				record[j] = ""
+			} else {
+				record[j] = fmt.Sprint(f.Interface())
+			}
+		}
+		if e := cw.Write(record); e != nil {
+			return e
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
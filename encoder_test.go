@@ -0,0 +1,85 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestNegotiateEncoder(t *testing.T) {
+	for _, c := range []struct {
+		accept    string
+		mediaType string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/xml, application/json;q=0.5", "application/xml"},
+		{"text/*;q=0.5, application/json", "application/json"},
+		{"application/json;q=0, */*", "application/xml"},
+		{"*/*, application/json;q=0, application/xml;q=0, text/xml;q=0", "application/yaml"},
+		{"application/*;q=0, */*", "text/xml"},
+		{"application/*;q=0, application/msgpack, */*;q=0.1", "application/msgpack"},
+		{"application/json;q=0", ""},
+		{"*/*;q=0", ""},
+		{"image/png", ""},
+	} {
+		_, mediaType := negotiateEncoder(c.accept, []string{"a"})
+		if mediaType != c.mediaType {
+			t.Errorf("Accept %q: %q, expected %q", c.accept, mediaType, c.mediaType)
+		}
+	}
+}
+
+type csvRow struct {
+	ID     int    `json:"id"`
+	Name   string `csv:"full_name"`
+	Secret string `json:"-"`
+}
+
+func TestProducesPinnedEncoder(t *testing.T) {
+	s := newTestServer()
+	csv := NewProperty(ProducesProperty, "text/csv")
+	s.GET("/rows", func(ctx *RequestCtx) interface{} {
+		return []*csvRow{{1, "a", "x"}, nil, {2, "b,c", "y"}}
+	}, csv)
+	s.GET("/map", func(ctx *RequestCtx) interface{} {
+		return map[string]int{"a": 1}
+	}, csv)
+
+	w := serve(s, "GET", "/rows", http.Header{"Accept": {"application/json"}})
+	if w.Header().Get("Content-Type") != "text/csv" || w.Body.String() != "id,full_name\n1,a\n2,\"b,c\"\n" {
+		t.Errorf("rows: %s %q", w.Header().Get("Content-Type"), w.Body.String())
+	}
+	if w := serve(s, "GET", "/map", nil); w.Code != http.StatusInternalServerError {
+		t.Errorf("map: %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestNegotiatedResponse(t *testing.T) {
+	s := newTestServer()
+	s.GET("/map", func(ctx *RequestCtx) interface{} {
+		return map[string]int{"a": 1}
+	})
+
+	w := serve(s, "GET", "/map", http.Header{"Accept": {"application/xml;q=0.9, application/yaml;q=0.5"}})
+	if w.Header().Get("Content-Type") != "application/yaml" || w.Header().Get("Vary") != "Accept" {
+		t.Errorf("maps are not encoded as xml: %s", w.Header().Get("Content-Type"))
+	}
+	if w := serve(s, "GET", "/map", http.Header{"Accept": {"image/png"}}); w.Code != http.StatusNotAcceptable {
+		t.Errorf("image/png: %d", w.Code)
+	}
+}
+
+func TestCSVEncoderRejectsUnsupported(t *testing.T) {
+	for _, v := range []interface{}{map[string]int{"a": 1}, []int{1}, "a", nil} {
+		if e := new(CSVEncoder).Encode(&bytes.Buffer{}, v); e == nil {
+			t.Errorf("%T: encoded", v)
+		}
+	}
+}
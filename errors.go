@@ -0,0 +1,212 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+)
+
+// error returned by handlers to respond a specific status, rendered by an ErrorRenderer negotiated by Accept
+//
+//	eg: return NotFound("user %d not found", id).WithCode("USER_NOT_FOUND")
+type HTTPError struct {
+	Status int
+
+	// machine-readable error code, optional
+	Code    string
+	Message string
+
+	// additional information, eg: invalid fields
+	Details interface{}
+}
+
+func NewHTTPError(status int, format string, args ...interface{}) *HTTPError {
+	message := format
+	if len(args) > 0 {
+		message = fmt.Sprintf(format, args...)
+	}
+	return &HTTPError{Status: status, Message: message}
+}
+
+func (e *HTTPError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%d %s", e.Status, e.Message)
+}
+
+func (e *HTTPError) WithCode(code string) *HTTPError {
+	e.Code = code
+	return e
+}
+
+func (e *HTTPError) WithDetails(details interface{}) *HTTPError {
+	e.Details = details
+	return e
+}
+
+func BadRequest(format string, args ...interface{}) *HTTPError {
+	return NewHTTPError(http.StatusBadRequest, format, args...)
+}
+
+func Unauthorized(format string, args ...interface{}) *HTTPError {
+	return NewHTTPError(http.StatusUnauthorized, format, args...)
+}
+
+func Forbidden(format string, args ...interface{}) *HTTPError {
+	return NewHTTPError(http.StatusForbidden, format, args...)
+}
+
+func NotFound(format string, args ...interface{}) *HTTPError {
+	return NewHTTPError(http.StatusNotFound, format, args...)
+}
+
+func Conflict(format string, args ...interface{}) *HTTPError {
+	return NewHTTPError(http.StatusConflict, format, args...)
+}
+
+func UnprocessableEntity(format string, args ...interface{}) *HTTPError {
+	return NewHTTPError(http.StatusUnprocessableEntity, format, args...)
+}
+
+func InternalServerError(format string, args ...interface{}) *HTTPError {
+	return NewHTTPError(http.StatusInternalServerError, format, args...)
+}
+
+// convert errors returned by handlers, unknown errors are responded as 500
+func toHTTPError(e error) *HTTPError {
+	switch err := e.(type) {
+	case *HTTPError:
+		return err
+	case *BindingError:
+		return BadRequest(err.Message).WithCode("INVALID_REQUEST").WithDetails(err.Errors)
+	case *ErrUnsupportedMediaType:
+		return NewHTTPError(http.StatusUnsupportedMediaType, err.Error())
+	default:
+		return InternalServerError(e.Error())
+	}
+}
+
+// renderer of HTTPError negotiated by the Accept header, register it as a kinoko spore
+// a renderer replaces the registered one of the same media type
+type ErrorRenderer interface {
+	// media types produced, the first one is preferred
+	MediaTypes() []string
+	Render(wr http.ResponseWriter, r *http.Request, err *HTTPError)
+}
+
+// registered renderers in order, the first one is used if the client accepts anything
+var errorRenderers = struct {
+	sync.RWMutex
+	renderers []ErrorRenderer
+}{}
+
+func init() {
+	RegisterErrorRenderer(new(ProblemRenderer))
+	RegisterErrorRenderer(new(HTMLErrorRenderer))
+}
+
+func RegisterErrorRenderer(renderer ErrorRenderer) {
+	errorRenderers.Lock()
+	defer errorRenderers.Unlock()
+	for i, r := range errorRenderers.renderers {
+		for _, t := range r.MediaTypes() {
+			if containsFold(renderer.MediaTypes(), t) {
+				errorRenderers.renderers[i] = renderer
+				return
+			}
+		}
+	}
+	errorRenderers.renderers = append(errorRenderers.renderers, renderer)
+}
+
+// render the error by the most acceptable renderer, falls back to the first one
+func renderError(wr http.ResponseWriter, r *http.Request, err *HTTPError) {
+	errorRenderers.RLock()
+	renderer := errorRenderers.renderers[0]
+	ranges := parseAccept(r.Header.Get("Accept"))
+negotiate:
+	for _, accept := range ranges {
+		for _, candidate := range errorRenderers.renderers {
+			for _, t := range candidate.MediaTypes() {
+				if accept.accepts(ranges, t) {
+					renderer = candidate
+					break negotiate
+				}
+			}
+		}
+	}
+	errorRenderers.RUnlock()
+
+	wr.Header().Add("Vary", "Accept")
+	renderer.Render(wr, r, err)
+}
+
+// RFC 7807 problem details, code and details are extension members
+type ProblemRenderer struct {
+}
+
+type problem struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance,omitempty"`
+	Code     string      `json:"code,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+}
+
+func (*ProblemRenderer) MediaTypes() []string {
+	return []string{"application/problem+json", "application/json"}
+}
+
+func (*ProblemRenderer) Render(wr http.ResponseWriter, r *http.Request, err *HTTPError) {
+	bytes, e := json.Marshal(problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(err.Status),
+		Status:   err.Status,
+		Detail:   err.Message,
+		Instance: r.URL.Path,
+		Code:     err.Code,
+		Details:  err.Details,
+	})
+	if e != nil {
+		HttpError(wr, http.StatusInternalServerError, e.Error(), false)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/problem+json")
+	wr.Header().Set("X-Content-Type-Options", "nosniff")
+	wr.WriteHeader(err.Status)
+	_, _ = wr.Write(bytes)
+}
+
+type HTMLErrorRenderer struct {
+}
+
+func (*HTMLErrorRenderer) MediaTypes() []string {
+	return []string{"text/html"}
+}
+
+// details are rendered as indented json
+func (*HTMLErrorRenderer) Render(wr http.ResponseWriter, r *http.Request, err *HTTPError) {
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	wr.Header().Set("X-Content-Type-Options", "nosniff")
+	wr.WriteHeader(err.Status)
+
+	details := ""
+	if err.Details != nil {
+		if bytes, e := json.MarshalIndent(err.Details, "", "  "); e == nil {
+			details = "<pre>" + template.HTMLEscapeString(string(bytes)) + "</pre>"
+		}
+	}
+	html := fmt.Sprintf(errorPage, err.Status, http.StatusText(err.Status), template.HTMLEscapeString(err.Message), details)
+	_, _ = fmt.Fprintln(wr, html)
+}
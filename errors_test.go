@@ -0,0 +1,107 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func errorServer() *HttpServer {
+	s := newTestServer()
+	s.GET("/users/:id", func(ctx *RequestCtx) interface{} {
+		return NotFound("user %s not found", ctx.PathVariable["id"]).WithCode("USER_NOT_FOUND")
+	})
+	return s
+}
+
+func TestProblemRenderer(t *testing.T) {
+	w := serve(errorServer(), "GET", "/users/7", nil)
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("%d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var p problem
+	if e := json.Unmarshal(w.Body.Bytes(), &p); e != nil {
+		t.Fatal(e)
+	}
+	expected := problem{Type: "about:blank", Title: "Not Found", Status: 404, Detail: "user 7 not found", Instance: "/users/7", Code: "USER_NOT_FOUND"}
+	if p != expected {
+		t.Errorf("%+v", p)
+	}
+}
+
+func TestErrorRendererNegotiation(t *testing.T) {
+	s := errorServer()
+	for accept, contentType := range map[string]string{
+		"":                                    "application/problem+json",
+		"*/*":                                 "application/problem+json",
+		"application/json":                    "application/problem+json",
+		"text/html,application/xhtml+xml":     "text/html; charset=utf-8",
+		"application/json;q=0.5, text/html":   "text/html; charset=utf-8",
+		"application/*;q=0, */*":              "text/html; charset=utf-8",
+		"image/png":                           "application/problem+json",
+		"text/html;q=0, application/json;q=0": "application/problem+json",
+	} {
+		w := serve(s, "GET", "/users/7", http.Header{"Accept": {accept}})
+		if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != contentType {
+			t.Errorf("Accept %q: %d %s, expected %s", accept, w.Code, w.Header().Get("Content-Type"), contentType)
+		}
+	}
+}
+
+func TestRouterErrorsRendered(t *testing.T) {
+	s := errorServer()
+	if w := serve(s, "GET", "/missing", nil); w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("not found: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	w := serve(s, "DELETE", "/users/7", http.Header{"Accept": {"text/html"}})
+	if w.Code != http.StatusMethodNotAllowed || !strings.Contains(w.Body.String(), "method DELETE is not allowed") {
+		t.Errorf("not allowed: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestToHTTPError(t *testing.T) {
+	for e, status := range map[error]int{
+		Conflict("taken"):                                http.StatusConflict,
+		&BindingError{Message: "invalid request"}:        http.StatusBadRequest,
+		&ErrUnsupportedMediaType{MediaType: "a/b"}:       http.StatusUnsupportedMediaType,
+		UnprocessableEntity("%d items", 2).WithCode("X"): http.StatusUnprocessableEntity,
+	} {
+		if actual := toHTTPError(e).Status; actual != status {
+			t.Errorf("%v: %d, expected %d", e, actual, status)
+		}
+	}
+}
+
+// renders errors as plain text
+type textErrorRenderer struct{}
+
+func (textErrorRenderer) MediaTypes() []string {
+	return []string{"text/plain"}
+}
+
+func (textErrorRenderer) Render(wr http.ResponseWriter, r *http.Request, err *HTTPError) {
+	wr.Header().Set("Content-Type", "text/plain")
+	wr.WriteHeader(err.Status)
+	_, _ = wr.Write([]byte(err.Error()))
+}
+
+func TestRegisterErrorRenderer(t *testing.T) {
+	RegisterErrorRenderer(textErrorRenderer{})
+	defer func() {
+		errorRenderers.Lock()
+		errorRenderers.renderers = errorRenderers.renderers[:len(errorRenderers.renderers)-1]
+		errorRenderers.Unlock()
+	}()
+
+	w := serve(errorServer(), "GET", "/users/7", http.Header{"Accept": {"text/plain"}})
+	if w.Body.String() != "404 USER_NOT_FOUND: user 7 not found" {
+		t.Errorf("%d %q", w.Code, w.Body.String())
+	}
+}
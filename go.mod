@@ -2,4 +2,7 @@ module github.com/kinoko-projects/kinoko_web
 
 go 1.12
 
-require github.com/kinoko-projects/kinoko v1.0.1
+require (
+	github.com/kinoko-projects/kinoko v1.0.1
+	gopkg.in/yaml.v2 v2.2.2
+)
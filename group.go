@@ -0,0 +1,112 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import "strings"
+
+// sub-mapper created by RequestMapper.Group
+// routes mapped by a group get its prefix, inherit its properties and are guarded by its interceptors
+type routeGroup struct {
+	server     *HttpServer
+	prefix     string
+	properties []HandlerProperties
+
+	// virtual host of the routes, nil for the default one
+	host *virtualHost
+	// pending changes of a batch, nil publishes every route once mapped
+	tx *routeTx
+
+	// interceptors of the prefix on the host, shared by the groups of the same prefix
+	// they guard every route below the prefix, even the ones mapped before or by other mappers
+	interceptorChain *InterceptorChain
+}
+
+func newRouteGroup(server *HttpServer, parent *routeGroup, prefix string, properties []HandlerProperties) *routeGroup {
+	g := &routeGroup{server: server}
+	if parent != nil {
+		g.host = parent.host
+		g.tx = parent.tx
+		g.prefix = joinPattern(parent.prefix, prefix)
+		g.properties = append(g.properties, parent.properties...)
+	} else {
+		g.prefix = joinPattern("", prefix)
+	}
+	g.properties = append(g.properties, properties...)
+	g.interceptorChain = server.handlers.groupChain(g.host, g.prefix)
+	return g
+}
+
+func (g *routeGroup) GET(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
+	return g.Mapping(Get, pattern, handler, properties...)
+}
+func (g *routeGroup) POST(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
+	return g.Mapping(Post, pattern, handler, properties...)
+}
+func (g *routeGroup) DELETE(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
+	return g.Mapping(Delete, pattern, handler, properties...)
+}
+func (g *routeGroup) PUT(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
+	return g.Mapping(Put, pattern, handler, properties...)
+}
+func (g *routeGroup) PATCH(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
+	return g.Mapping(Patch, pattern, handler, properties...)
+}
+func (g *routeGroup) HEAD(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
+	return g.Mapping(Head, pattern, handler, properties...)
+}
+func (g *routeGroup) OPTIONS(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
+	return g.Mapping(Options, pattern, handler, properties...)
+}
+func (g *routeGroup) Any(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
+	return g.Mapping(AnyMethod, pattern, handler, properties...)
+}
+
+// group properties are applied first so the route can override them
+func (g *routeGroup) Mapping(method RequestMethod, pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
+	g.mapRoute(method, pattern, handler, handler, properties)
+	return g
+}
+
+func (g *routeGroup) Bind(method RequestMethod, pattern string, fn interface{}, properties ...HandlerProperties) RequestMapper {
+	g.mapRoute(method, pattern, bindHandler(joinPattern(g.prefix, pattern), fn), fn, properties)
+	return g
+}
+
+func (g *routeGroup) mapRoute(method RequestMethod, pattern string, handler RequestHandlerFunc, source interface{}, properties []HandlerProperties) {
+	props := make([]HandlerProperties, 0, len(g.properties)+len(properties))
+	props = append(props, g.properties...)
+	props = append(props, properties...)
+	g.server.mapRoute(g.scope(), method, joinPattern(g.prefix, pattern), handler, source, props)
+}
+
+func (g *routeGroup) Group(prefix string, properties ...HandlerProperties) RequestMapper {
+	return newRouteGroup(g.server, g, prefix, properties)
+}
+
+func (g *routeGroup) AddInterceptor(interceptor Interceptor) {
+	g.interceptorChain.AddInterceptor(interceptor)
+	g.server.reselectInterceptors(g.scope(), g.interceptorChain)
+}
+
+func (g *routeGroup) AddAroundInterceptor(interceptor AroundInterceptor) {
+	g.interceptorChain.AddAroundInterceptor(interceptor)
+	g.server.reselectInterceptors(g.scope(), g.interceptorChain)
+}
+
+// joinPattern("/admin/", "/users") => "/admin/users"
+func joinPattern(prefix string, pattern string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	pattern = strings.Trim(strings.TrimSpace(pattern), "/")
+	switch {
+	case prefix == "":
+		return "/" + pattern
+	case pattern == "":
+		return "/" + prefix
+	default:
+		return "/" + prefix + "/" + pattern
+	}
+}
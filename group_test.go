@@ -0,0 +1,57 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import "testing"
+
+// blocks every request it intercepts with its name
+type blockingInterceptor string
+
+func (b blockingInterceptor) Priority() int {
+	return 0
+}
+
+func (b blockingInterceptor) Intercept(ctx *RequestCtx, properties map[string]interface{}) (InterceptorAction, interface{}) {
+	return Block, string(b)
+}
+
+func TestGroupInterceptorsGuardPrefix(t *testing.T) {
+	s := newTestServer()
+	s.Group("/admin").GET("/x", text("x"))
+	s.GET("/admin/z", text("z"))
+	s.GET("/administrator", text("administrator"))
+	s.Group("/admin").Group("/users").GET("/", text("users"))
+
+	// added by another group of the same prefix, after the routes are mapped
+	s.Group("admin/").AddInterceptor(blockingInterceptor("admin"))
+	s.Group("/admin").GET("/y", text("y"))
+
+	for url, expected := range map[string]string{
+		"/admin/x":       "admin",
+		"/admin/y":       "admin",
+		"/admin/z":       "admin",
+		"/admin/users":   "admin",
+		"/administrator": "administrator",
+	} {
+		if w := serve(s, "GET", url, nil); w.Body.String() != expected {
+			t.Errorf("%s: %q, expected %q", url, w.Body.String(), expected)
+		}
+	}
+}
+
+func TestNestedGroupInterceptorsOrder(t *testing.T) {
+	s := newTestServer()
+	api := s.Group("/api")
+	api.Group("/v1").AddInterceptor(blockingInterceptor("v1"))
+	api.Group("/v1").GET("/users", text("users"))
+	api.AddInterceptor(blockingInterceptor("api"))
+
+	// interceptors of the outer group run first
+	if w := serve(s, "GET", "/api/v1/users", nil); w.Body.String() != "api" {
+		t.Errorf("/api/v1/users: %q", w.Body.String())
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// standard net/http middleware
+//
+//	eg: s.Use(handlers.CompressHandler)
+type Middleware func(http.Handler) http.Handler
+
+// property key of middlewares wrapping a single route, the value is a Middleware or []Middleware
+// route middlewares run after the global ones and before the interceptors
+//
+//	eg: s.GET("/report", handler, NewProperty(MiddlewareProperty, []Middleware{timeout, gzip}))
+const MiddlewareProperty = "middleware"
+
+// placeholder of the path below a mounted prefix
+const mountVariable = "mount"
+
+// wrap the handler, the first middleware is the outermost one
+func chainMiddlewares(h http.Handler, middlewares []Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+func routeMiddlewares(properties map[string]interface{}) []Middleware {
+	switch m := properties[MiddlewareProperty].(type) {
+	case Middleware:
+		return []Middleware{m}
+	case func(http.Handler) http.Handler:
+		return []Middleware{m}
+	case []Middleware:
+		return m
+	}
+	return nil
+}
+
+// middlewares wrapping every request, including unmapped ones
+// must be added before the server is started
+func (s *HttpServer) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+// handler of the server wrapped by its middlewares, can be served by another http server
+func (s *HttpServer) Handler() http.Handler {
+	return chainMiddlewares(s.handlers, s.middlewares)
+}
+
+// serve the route by a standard handler, its response is written directly
+func adaptHandler(h http.Handler) RequestHandlerFunc {
+	return func(ctx *RequestCtx) interface{} {
+		h.ServeHTTP(ctx.ResponseWriter, ctx.Request)
+		return nil
+	}
+}
+
+// serve the prefix and every path below by h, the prefix is stripped from the request url like http.StripPrefix
+// the prefix itself is served as "/"
+func mountHandler(prefix string, h http.Handler) RequestHandlerFunc {
+	depth := 0
+	if p := strings.Trim(prefix, "/"); p != "" {
+		depth = strings.Count(p, "/") + 1
+	}
+	return func(ctx *RequestCtx) interface{} {
+		r := ctx.Request
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/" + ctx.PathVariable[mountVariable]
+		r2.URL.RawPath = ""
+
+		// keep escaped slashes if the path is not rewritten by the router
+		if r.URL.RawPath != "" {
+			raw := strings.TrimPrefix(r.URL.RawPath, "/")
+			for i := 0; i < depth && raw != ""; i++ {
+				if n := strings.IndexByte(raw, '/'); n >= 0 {
+					raw = raw[n+1:]
+				} else {
+					raw = ""
+				}
+			}
+			if p, e := url.PathUnescape("/" + raw); e == nil && p == r2.URL.Path {
+				r2.URL.RawPath = "/" + raw
+			}
+		}
+		h.ServeHTTP(ctx.ResponseWriter, r2)
+		return nil
+	}
+}
+
+func (s *HttpServer) Handle(method RequestMethod, pattern string, h http.Handler, properties ...HandlerProperties) RequestMapper {
+	s.mapRoute(routeScope{}, method, pattern, adaptHandler(h), h, properties)
+	return s
+}
+
+func (s *HttpServer) Mount(prefix string, h http.Handler, properties ...HandlerProperties) RequestMapper {
+	prefix = joinPattern("", prefix)
+	s.mapRoute(routeScope{}, AnyMethod, joinPattern(prefix, "*"+mountVariable+"?"), mountHandler(prefix, h), h, properties)
+	return s
+}
+
+func (g *routeGroup) Handle(method RequestMethod, pattern string, h http.Handler, properties ...HandlerProperties) RequestMapper {
+	g.mapRoute(method, pattern, adaptHandler(h), h, properties)
+	return g
+}
+
+func (g *routeGroup) Mount(prefix string, h http.Handler, properties ...HandlerProperties) RequestMapper {
+	g.mapRoute(AnyMethod, joinPattern(prefix, "*"+mountVariable+"?"), mountHandler(joinPattern(g.prefix, prefix), h), h, properties)
+	return g
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// appends the name to the X-Trace header before calling the next handler
+func tracing(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+			wr.Header().Add("X-Trace", name)
+			next.ServeHTTP(wr, r)
+		})
+	}
+}
+
+// echoes the path seen by the handler
+var echoPath = http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+	_, _ = fmt.Fprint(wr, r.URL.Path, " ", r.URL.EscapedPath())
+})
+
+func TestHandle(t *testing.T) {
+	s := newTestServer()
+	s.Handle(Get, "/health", http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		wr.WriteHeader(http.StatusNoContent)
+	}))
+	s.Group("/api").Handle(Post, "/echo", echoPath)
+
+	if w := serve(s, "GET", "/health", nil); w.Code != http.StatusNoContent {
+		t.Errorf("/health: %d", w.Code)
+	}
+	if w := serve(s, "POST", "/api/echo", nil); w.Body.String() != "/api/echo /api/echo" {
+		t.Errorf("/api/echo: %q", w.Body.String())
+	}
+}
+
+func TestMount(t *testing.T) {
+	s := newTestServer()
+	s.Mount("/legacy", echoPath)
+	s.Group("/v1").Mount("/files/", echoPath)
+	s.GET("/legacy/override", text("override"))
+
+	for url, expected := range map[string]string{
+		"/legacy":             "/ /",
+		"/legacy/":            "/ /",
+		"/legacy/a/b":         "/a/b /a/b",
+		"/legacy/a%2Fb/c":     "/a/b/c /a%2Fb/c",
+		"/v1/files/x.txt":     "/x.txt /x.txt",
+		"/v1/files/dir/a%20b": "/dir/a b /dir/a%20b",
+		"/legacy/override":    "override",
+	} {
+		if w := serve(s, "GET", url, nil); w.Body.String() != expected {
+			t.Errorf("%s: %d %q, expected %q", url, w.Code, w.Body.String(), expected)
+		}
+	}
+	if w := serve(s, "DELETE", "/legacy/a", nil); w.Body.String() != "/a /a" {
+		t.Errorf("mounted handlers serve any method: %d", w.Code)
+	}
+}
+
+func TestMiddlewares(t *testing.T) {
+	s := newTestServer()
+	s.Use(tracing("outer"), tracing("inner"))
+	s.GET("/a", text("a"), NewProperty(MiddlewareProperty, []Middleware{tracing("route"), tracing("route2")}))
+	s.GET("/b", text("b"), NewProperty(MiddlewareProperty, tracing("single")))
+	s.AddInterceptor(blockingInterceptor("intercepted"))
+
+	for url, expected := range map[string]string{
+		"/a":       "outer,inner,route,route2",
+		"/b":       "outer,inner,single",
+		"/missing": "outer,inner",
+	} {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if trace := strings.Join(w.Header()["X-Trace"], ","); trace != expected {
+			t.Errorf("%s: %q, expected %q", url, trace, expected)
+		}
+		if url != "/missing" && w.Body.String() != "intercepted" {
+			t.Errorf("%s: route middlewares must wrap the interceptors: %q", url, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"net"
+	"strings"
+)
+
+// routes served for the hosts matching the pattern, with their own tries and interceptors
+// labels in braces are placeholders added to path variables, the port is ignored unless the pattern has one
+// requests of hosts matching no virtual host are served by the default routes
+//
+//	eg: s.Host("{tenant}.example.com").GET("/users", handler) => ctx.PathVariable["tenant"]
+type virtualHost struct {
+	pattern string
+	labels  []string
+	port    bool
+
+	// mapper of the host, its interceptors guard every route of the host
+	group *routeGroup
+}
+
+// mapper of the virtual host, the same mapper is returned for the same pattern
+func (s *HttpServer) Host(pattern string) RequestMapper {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		panic("empty host")
+	}
+	tx := s.handlers.begin()
+	defer s.handlers.commit(tx)
+	hosts := tx.state.hosts
+	for _, h := range hosts {
+		if strings.EqualFold(h.pattern, pattern) {
+			return h.group
+		}
+	}
+
+	h := &virtualHost{pattern: pattern, port: strings.Contains(pattern, ":")}
+	// placeholder names keep their case
+	for _, label := range strings.Split(pattern, ".") {
+		if !isHostPlaceholder(label) {
+			label = strings.ToLower(label)
+		}
+		h.labels = append(h.labels, label)
+	}
+	h.group = newRouteGroup(s, nil, "", nil)
+	h.group.host = h
+	h.group.interceptorChain = s.handlers.groupChain(h, h.group.prefix)
+
+	// literal hosts are matched first
+	n := len(hosts)
+	if !strings.Contains(pattern, "{") {
+		n = 0
+		for n < len(hosts) && !strings.Contains(hosts[n].pattern, "{") {
+			n++
+		}
+	}
+	tx.state.hosts = append(hosts[:n], append([]*virtualHost{h}, hosts[n:]...)...)
+	return h.group
+}
+
+// virtual host serving the request host, nil for the default routes
+// placeholders of the virtual host are set to vars
+func (st *routeState) matchHost(host string, vars map[string]string) *virtualHost {
+	if len(st.hosts) == 0 {
+		return nil
+	}
+	host = strings.ToLower(host)
+	hostname := host
+	if h, _, e := net.SplitHostPort(host); e == nil {
+		hostname = h
+	}
+	for _, h := range st.hosts {
+		name := hostname
+		if h.port {
+			name = host
+		}
+		if h.match(name, vars) {
+			return h
+		}
+	}
+	return nil
+}
+
+func (h *virtualHost) match(host string, vars map[string]string) bool {
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	if len(labels) != len(h.labels) {
+		return false
+	}
+	for i, label := range h.labels {
+		if isHostPlaceholder(label) {
+			if labels[i] == "" {
+				return false
+			}
+			continue
+		}
+		if label != labels[i] {
+			return false
+		}
+	}
+	for i, label := range h.labels {
+		if isHostPlaceholder(label) {
+			vars[label[1:len(label)-1]] = labels[i]
+		}
+	}
+	return true
+}
+
+func isHostPlaceholder(label string) bool {
+	return len(label) > 2 && label[0] == '{' && label[len(label)-1] == '}'
+}
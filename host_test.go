@@ -0,0 +1,81 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	s := newTestServer()
+	s.GET("/", text("default"))
+	s.Host("{tenant}.example.com").GET("/", func(ctx *RequestCtx) interface{} {
+		return "tenant " + ctx.PathVariable["tenant"]
+	})
+	s.Host("API.example.com").GET("/", text("api"))
+	s.Host("admin.example.com:8443").GET("/", text("admin"))
+	s.Host("{app}.{region}.example.com").GET("/:app", func(ctx *RequestCtx) interface{} {
+		// path variables are preferred to host placeholders of the same name
+		return ctx.PathVariable["app"] + " " + ctx.PathVariable["region"]
+	})
+
+	for host, expected := range map[string]string{
+		"example.com":            "default",
+		"other.org":              "default",
+		"acme.example.com":       "tenant acme",
+		"Acme.Example.com.":      "tenant acme",
+		"acme.example.com:8080":  "tenant acme",
+		"api.example.com":        "api",
+		"admin.example.com:8443": "admin",
+		"admin.example.com":      "tenant admin",
+		"a.b.c.example.com":      "default",
+	} {
+		if w := serve(s, "GET", "http://"+host+"/", nil); w.Body.String() != expected {
+			t.Errorf("%s: %d %q, expected %q", host, w.Code, w.Body.String(), expected)
+		}
+	}
+	if w := serve(s, "GET", "http://shop.eu.example.com/web", nil); w.Body.String() != "web eu" {
+		t.Errorf("placeholders: %q", w.Body.String())
+	}
+}
+
+func TestHostMapperIsShared(t *testing.T) {
+	s := newTestServer()
+	if s.Host("a.example.com") != s.Host(" A.example.com ") {
+		t.Error("patterns differing by case return different mappers")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("empty host is accepted")
+		}
+	}()
+	s.Host("")
+}
+
+func TestHostInterceptors(t *testing.T) {
+	s := newTestServer()
+	s.GET("/admin/users", text("default users"))
+	a := s.Host("a.example.com")
+	a.GET("/admin/users", text("a users"))
+	a.GET("/public", text("a public"))
+	s.Host("b.example.com").GET("/admin/users", text("b users"))
+
+	a.Group("/admin").AddInterceptor(blockingInterceptor("a admin"))
+	s.Host("b.example.com").AddInterceptor(blockingInterceptor("b"))
+
+	for url, expected := range map[string]string{
+		"http://example.com/admin/users":   "default users",
+		"http://a.example.com/admin/users": "a admin",
+		"http://a.example.com/public":      "a public",
+		"http://b.example.com/admin/users": "b",
+	} {
+		if w := serve(s, "GET", url, nil); w.Code != http.StatusOK || w.Body.String() != expected {
+			t.Errorf("%s: %d %q, expected %q", url, w.Code, w.Body.String(), expected)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package kinoko_web
 
 import (
-	"sort"
+	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type InterceptorAction int
@@ -19,6 +21,7 @@ const (
 	Block
 
 	// skip other interceptors, enter normal handler, not recommended
+	// authentication and authorization are still checked
 	// returned value must be nil
 	Skip
 )
@@ -29,35 +32,177 @@ const (
 
 type Interceptor interface {
 
-	// higher prior
+	// lower priority is called first, see InterceptorChain
 	Priority() int
 	Intercept(ctx *RequestCtx, properties map[string]interface{}) (InterceptorAction, interface{})
 }
 
+// interceptor wrapping the rest of the chain and the handler, it may observe or replace the returned value
+// next calls the following interceptors and the handler, not calling it blocks the request
+//
+//	eg: func (t *Timing) Intercept(ctx *RequestCtx, properties map[string]interface{}, next func() interface{}) interface{} {
+//		start := time.Now()
+//		defer func() { logger.Info(ctx.Request.URL.Path, time.Since(start)) }()
+//		return next()
+//	}
+type AroundInterceptor interface {
+
+	// lower priority is called first, see InterceptorChain
+	Priority() int
+	Intercept(ctx *RequestCtx, properties map[string]interface{}, next func() interface{}) interface{}
+}
+
+// interceptors are called by ascending priority, the first one wraps the others
+// constraints of OrderedInterceptor come before priorities, equal ones keep the registration order
+// the order is published as a whole, requests never see a chain being modified
+// a frozen chain rejects new interceptors, the global chain is frozen once the server is initialized
 type InterceptorChain struct {
 	sync.Mutex
-	interceptor []Interceptor
+
+	// Interceptor and AroundInterceptor in registration order
+	registered []interceptorEntry
+	frozen     bool
+	// constraints which can not be satisfied, the order falls back to priorities
+	err error
+
+	// []interceptorEntry in calling order
+	ordered atomic.Value
+}
+
+type interceptorEntry struct {
+	name        string
+	priority    int
+	interceptor Interceptor
+	around      AroundInterceptor
+
+	// names of OrderedInterceptor constraints
+	before []string
+	after  []string
+
+	// routes of a SelectiveInterceptor, nil applies to every route
+	routes *InterceptorRoutes
+	// methods of the routes, nil accepts every method
+	methods map[RequestMethod]bool
+
+	// access control, called even if a preceding interceptor skips the chain
+	guard bool
 }
 
 func NewInterceptorChain() *InterceptorChain {
-	return &InterceptorChain{interceptor: []Interceptor{}}
+	return &InterceptorChain{}
 }
 
 func (r *InterceptorChain) AddInterceptor(interceptor Interceptor) {
+	r.addEntry(newInterceptorEntry(interceptor.Priority(), interceptor, nil))
+}
+
+func (r *InterceptorChain) AddAroundInterceptor(interceptor AroundInterceptor) {
+	r.addEntry(newInterceptorEntry(interceptor.Priority(), nil, interceptor))
+}
+
+// add an access control interceptor which can not be skipped, eg: auth
+func (r *InterceptorChain) addGuard(interceptor Interceptor) {
+	entry := newInterceptorEntry(interceptor.Priority(), interceptor, nil)
+	entry.guard = true
+	r.addEntry(entry)
+}
+
+func newInterceptorEntry(priority int, interceptor Interceptor, around AroundInterceptor) interceptorEntry {
+	entry := interceptorEntry{priority: priority, interceptor: interceptor, around: around}
+	var i interface{} = interceptor
+	if around != nil {
+		i = around
+	}
+	entry.name = interceptorName(i)
+	if o, ok := i.(OrderedInterceptor); ok {
+		entry.before, entry.after = o.Before(), o.After()
+	}
+	if s, ok := i.(SelectiveInterceptor); ok {
+		routes := s.Routes()
+		entry.routes = &routes
+		if len(routes.Methods) > 0 {
+			entry.methods = map[RequestMethod]bool{}
+			for _, m := range routes.Methods {
+				entry.methods[RequestMethod(strings.ToUpper(strings.TrimSpace(string(m))))] = true
+			}
+		}
+	}
+	return entry
+}
+
+// classic and around interceptors are called in a single order
+func (r *InterceptorChain) addEntry(entry interceptorEntry) {
 	r.Lock()
-	ins := append(r.interceptor, interceptor)
-	sort.SliceStable(ins, func(i, j int) bool {
-		return ins[i].Priority() < ins[j].Priority()
-	})
-	r.interceptor = ins
-	r.Unlock()
+	defer r.Unlock()
+	if r.frozen {
+		panic(fmt.Sprintf("interceptor chain is frozen, %s can not be added", entry.name))
+	}
+	r.registered = append(r.registered, entry)
+	var ordered []interceptorEntry
+	ordered, r.err = orderInterceptors(r.registered)
+	r.ordered.Store(ordered)
+}
+
+// reject new interceptors, the error reports constraints which can not be satisfied
+func (r *InterceptorChain) Freeze() error {
+	r.Lock()
+	defer r.Unlock()
+	r.frozen = true
+	return r.err
+}
+
+func (r *InterceptorChain) validate() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.err
+}
+
+// interceptors in calling order
+func (r *InterceptorChain) snapshot() []interceptorEntry {
+	ordered, _ := r.ordered.Load().([]interceptorEntry)
+	return ordered
+}
+
+// effective order of the interceptors, one line each
+//
+//	eg: ["-10 *app.Recovery", "0 auth", "0 *app.Audit after [auth]"]
+func (r *InterceptorChain) Dump() []string {
+	var lines []string
+	for _, entry := range r.snapshot() {
+		line := fmt.Sprintf("%d %s", entry.priority, entry.name)
+		if len(entry.before) > 0 {
+			line += fmt.Sprintf(" before %v", entry.before)
+		}
+		if len(entry.after) > 0 {
+			line += fmt.Sprintf(" after %v", entry.after)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// name of the interceptor, listed by Routes
+func (e interceptorEntry) String() string {
+	return e.name
 }
 
 // interceptorChain chain returns a bool tell if the request should be blocked or resumed
 // second parameter will be treat as the response body
 func (r *InterceptorChain) CallInterceptors(ctx *RequestCtx, properties map[string]interface{}) (bool, interface{}) {
-	for _, i := range r.interceptor {
-		action, ret := i.Intercept(ctx, properties)
+	action, ret := r.callInterceptors(ctx, properties)
+	return action == Block, ret
+}
+
+// returns Continue if every interceptor continued, otherwise the action which stopped the chain
+// guards are still called once an interceptor skips
+func (r *InterceptorChain) callInterceptors(ctx *RequestCtx, properties map[string]interface{}) (InterceptorAction, interface{}) {
+	skipped := false
+	for _, entry := range r.snapshot() {
+		// around interceptors need the handler
+		if entry.interceptor == nil || skipped && !entry.guard {
+			continue
+		}
+		action, ret := entry.interceptor.Intercept(ctx, properties)
 		switch action {
 		case Continue:
 			if ret != nil {
@@ -65,13 +210,70 @@ func (r *InterceptorChain) CallInterceptors(ctx *RequestCtx, properties map[stri
 			}
 			continue
 		case Block:
-			return true, ret
+			return Block, ret
+		case Skip:
+			if ret != nil {
+				logger.Warn("Skipped interceptors returns no nil value makes no sense")
+			}
+			skipped = true
+		}
+	}
+	if skipped {
+		return Skip, nil
+	}
+	return Continue, nil
+}
+
+// call the selected interceptors in order as an onion around the handler
+// Block returns the value of the interceptor, Skip calls the guards left and then the handler
+func invokeInterceptors(entries []interceptorEntry, ctx *RequestCtx, properties map[string]interface{}, handler func() interface{}) interface{} {
+	method := RequestMethod(ctx.Request.Method)
+	// method filters are only left for routes mapped by Any
+	accepts := func(entry interceptorEntry) bool {
+		return entry.methods == nil || entry.methods[method]
+	}
+	skip := func(index int) interface{} {
+		for ; index < len(entries); index++ {
+			if entry := entries[index]; entry.guard && accepts(entry) {
+				if action, ret := entry.interceptor.Intercept(ctx, properties); action == Block {
+					return ret
+				}
+			}
+		}
+		return handler()
+	}
+
+	var call func(index int) interface{}
+	call = func(index int) interface{} {
+		for index < len(entries) && !accepts(entries[index]) {
+			index++
+		}
+		if index == len(entries) {
+			return handler()
+		}
+		next := func() interface{} {
+			return call(index + 1)
+		}
+
+		entry := entries[index]
+		if entry.around != nil {
+			return entry.around.Intercept(ctx, properties, next)
+		}
+		action, ret := entry.interceptor.Intercept(ctx, properties)
+		switch action {
+		case Block:
+			return ret
 		case Skip:
 			if ret != nil {
 				logger.Warn("Skipped interceptors returns no nil value makes no sense")
 			}
-			return false, nil
+			return skip(index + 1)
+		default:
+			if ret != nil {
+				logger.Warn("Continued interceptors returns no nil value makes no sense")
+			}
+			return next()
 		}
 	}
-	return false, nil
+	return call(0)
 }
@@ -0,0 +1,154 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// appends "<name" before and ">name" after the rest of the chain to the trace
+type tracingAround struct {
+	name     string
+	priority int
+	trace    *[]string
+}
+
+func (a *tracingAround) Priority() int {
+	return a.priority
+}
+
+func (a *tracingAround) Intercept(ctx *RequestCtx, properties map[string]interface{}, next func() interface{}) interface{} {
+	*a.trace = append(*a.trace, "<"+a.name)
+	ret := next()
+	*a.trace = append(*a.trace, ">"+a.name)
+	return ret
+}
+
+// returns the action and appends its name to the trace
+type tracingInterceptor struct {
+	name     string
+	priority int
+	action   InterceptorAction
+	trace    *[]string
+}
+
+func (i *tracingInterceptor) Priority() int {
+	return i.priority
+}
+
+func (i *tracingInterceptor) Intercept(ctx *RequestCtx, properties map[string]interface{}) (InterceptorAction, interface{}) {
+	*i.trace = append(*i.trace, i.name)
+	if i.action == Block {
+		return Block, i.name
+	}
+	return i.action, nil
+}
+
+type replacingAround struct{}
+
+func (replacingAround) Priority() int {
+	return 0
+}
+
+func (replacingAround) Intercept(ctx *RequestCtx, properties map[string]interface{}, next func() interface{}) interface{} {
+	if s, ok := next().(string); ok {
+		return strings.ToUpper(s)
+	}
+	return nil
+}
+
+type shortCircuitAround struct{}
+
+func (shortCircuitAround) Priority() int {
+	return 0
+}
+
+func (shortCircuitAround) Intercept(ctx *RequestCtx, properties map[string]interface{}, next func() interface{}) interface{} {
+	return "cached"
+}
+
+func TestAroundInterceptorsOrder(t *testing.T) {
+	var trace []string
+	s := newTestServer()
+	s.GET("/", func(ctx *RequestCtx) interface{} {
+		trace = append(trace, "handler")
+		return "ok"
+	})
+	s.AddAroundInterceptor(&tracingAround{"outer", 0, &trace})
+	s.AddInterceptor(&tracingInterceptor{"classic", 1, Continue, &trace})
+	s.AddAroundInterceptor(&tracingAround{"inner", 2, &trace})
+	s.Group("/").AddAroundInterceptor(&tracingAround{"group", -1, &trace})
+
+	if w := serve(s, "GET", "/", nil); w.Body.String() != "ok" {
+		t.Errorf("%q", w.Body.String())
+	}
+	expected := []string{"<outer", "classic", "<inner", "<group", "handler", ">group", ">inner", ">outer"}
+	if !reflect.DeepEqual(trace, expected) {
+		t.Errorf("%v, expected %v", trace, expected)
+	}
+}
+
+func TestAroundInterceptorsActions(t *testing.T) {
+	var trace []string
+	s := newTestServer()
+	s.GET("/", func(ctx *RequestCtx) interface{} {
+		trace = append(trace, "handler")
+		return "ok"
+	})
+	s.AddAroundInterceptor(&tracingAround{"around", 0, &trace})
+	blocked := s.Group("/blocked")
+	blocked.AddInterceptor(&tracingInterceptor{"block", 0, Block, &trace})
+	blocked.GET("/", text("blocked handler"))
+	skipped := s.Group("/skipped")
+	skipped.AddInterceptor(&tracingInterceptor{"skip", 0, Skip, &trace})
+	skipped.AddAroundInterceptor(&tracingAround{"skipped", 1, &trace})
+	skipped.GET("/", text("skipped handler"))
+
+	for _, c := range []struct {
+		url, body string
+		trace     []string
+	}{
+		// blocking returns the value to the enclosing around interceptors
+		{"/blocked", "block", []string{"<around", "block", ">around"}},
+		// skipping calls the handler without the following interceptors
+		{"/skipped", "skipped handler", []string{"<around", "skip", ">around"}},
+	} {
+		trace = nil
+		if w := serve(s, "GET", c.url, nil); w.Body.String() != c.body || !reflect.DeepEqual(trace, c.trace) {
+			t.Errorf("%s: %q %v, expected %q %v", c.url, w.Body.String(), trace, c.body, c.trace)
+		}
+	}
+}
+
+func TestAroundInterceptorsReplaceResult(t *testing.T) {
+	s := newTestServer()
+	s.Group("/upper").AddAroundInterceptor(replacingAround{})
+	s.Group("/upper").GET("/", text("hello"))
+	s.Group("/cached").AddAroundInterceptor(shortCircuitAround{})
+	s.Group("/cached").GET("/", func(ctx *RequestCtx) interface{} {
+		t.Error("handler is called")
+		return nil
+	})
+
+	for url, expected := range map[string]string{"/upper": "HELLO", "/cached": "cached"} {
+		if w := serve(s, "GET", url, nil); w.Body.String() != expected {
+			t.Errorf("%s: %q, expected %q", url, w.Body.String(), expected)
+		}
+	}
+
+	listed := false
+	for _, r := range s.Routes() {
+		if r.Pattern == "/upper" {
+			listed = reflect.DeepEqual(r.Interceptors, []string{"kinoko_web.replacingAround"})
+		}
+	}
+	if !listed {
+		t.Errorf("around interceptors are not listed: %v", s.Routes())
+	}
+}
@@ -3,5 +3,5 @@ package kinoko_web
 import "github.com/kinoko-projects/kinoko"
 
 func init() {
-	kinoko.Application.Use(new(HttpConfig), new(HttpServer), new(SQL), new(SSLConfig), &sqlPropertiesHolder)
+	kinoko.Application.Use(new(HttpConfig), new(HttpServer), new(SQL), new(SSLConfig), new(CORSConfig), new(ErrorConfig), new(AdminConfig), new(RouterConfig), new(ViewConfig), new(AuthConfig), &sqlPropertiesHolder)
 }
@@ -0,0 +1,284 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// MessagePack encoder, structs are encoded as maps keyed by `msgpack` or `json` tags
+// values implementing encoding.TextMarshaler (eg: time.Time) are encoded as strings
+type MsgpackEncoder struct {
+}
+
+func (*MsgpackEncoder) MediaTypes() []string {
+	return []string{"application/msgpack", "application/x-msgpack"}
+}
+
+func (*MsgpackEncoder) Supports(v interface{}) bool {
+	return true
+}
+
+func (*MsgpackEncoder) Encode(w io.Writer, v interface{}) error {
+	m := &msgpackWriter{}
+	if e := m.encode(reflect.ValueOf(v)); e != nil {
+		return e
+	}
+	_, e := w.Write(m.buf)
+	return e
+}
+
+type msgpackWriter struct {
+	buf []byte
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+func (m *msgpackWriter) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		m.buf = append(m.buf, 0xc0)
+		return nil
+	}
+	if v.Type().Implements(textMarshalerType) && !(v.Kind() == reflect.Ptr && v.IsNil()) {
+		text, e := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if e != nil {
+			return e
+		}
+		m.writeString(string(text))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			m.buf = append(m.buf, 0xc0)
+			return nil
+		}
+		return m.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			m.buf = append(m.buf, 0xc3)
+		} else {
+			m.buf = append(m.buf, 0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		m.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		m.writeUint(v.Uint())
+	case reflect.Float32:
+		m.buf = append(m.buf, 0xca)
+		m.buf = appendUint32(m.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		m.buf = append(m.buf, 0xcb)
+		m.buf = appendUint64(m.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		m.writeString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			m.buf = append(m.buf, 0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			m.writeBinary(b)
+			return nil
+		}
+		m.writeHeader(v.Len(), 0x90, 15, 0xdc, 0xdd)
+		for i := 0; i < v.Len(); i++ {
+			if e := m.encode(v.Index(i)); e != nil {
+				return e
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			m.buf = append(m.buf, 0xc0)
+			return nil
+		}
+		m.writeHeader(v.Len(), 0x80, 15, 0xde, 0xdf)
+		iter := v.MapRange()
+		for iter.Next() {
+			if e := m.encode(iter.Key()); e != nil {
+				return e
+			}
+			if e := m.encode(iter.Value()); e != nil {
+				return e
+			}
+		}
+	case reflect.Struct:
+		return m.encodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %v", v.Type())
+	}
+	return nil
+}
+
+func (m *msgpackWriter) encodeStruct(v reflect.Value) error {
+	t := v.Type()
+	var names []string
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		omitEmpty := false
+		for _, key := range []string{"msgpack", "json"} {
+			if tag, ok := f.Tag.Lookup(key); ok {
+				parts := strings.Split(tag, ",")
+				if parts[0] != "" {
+					name = parts[0]
+				}
+				for _, p := range parts[1:] {
+					omitEmpty = omitEmpty || p == "omitempty"
+				}
+				break
+			}
+		}
+		if name == "-" || omitEmpty && isEmptyValue(v.Field(i)) {
+			continue
+		}
+		names = append(names, name)
+		fields = append(fields, i)
+	}
+
+	m.writeHeader(len(fields), 0x80, 15, 0xde, 0xdf)
+	for i, index := range fields {
+		m.writeString(names[i])
+		if e := m.encode(v.Field(index)); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// fix header for small sizes, 16 or 32 bits length otherwise
+func (m *msgpackWriter) writeHeader(n int, fix byte, fixMax int, code16 byte, code32 byte) {
+	switch {
+	case n <= fixMax:
+		m.buf = append(m.buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		m.buf = append(m.buf, code16)
+		m.buf = appendUint16(m.buf, uint16(n))
+	default:
+		m.buf = append(m.buf, code32)
+		m.buf = appendUint32(m.buf, uint32(n))
+	}
+}
+
+func (m *msgpackWriter) writeString(s string) {
+	n := len(s)
+	switch {
+	case n <= 31:
+		m.buf = append(m.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		m.buf = append(m.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		m.buf = append(m.buf, 0xda)
+		m.buf = appendUint16(m.buf, uint16(n))
+	default:
+		m.buf = append(m.buf, 0xdb)
+		m.buf = appendUint32(m.buf, uint32(n))
+	}
+	m.buf = append(m.buf, s...)
+}
+
+func (m *msgpackWriter) writeBinary(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		m.buf = append(m.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		m.buf = append(m.buf, 0xc5)
+		m.buf = appendUint16(m.buf, uint16(n))
+	default:
+		m.buf = append(m.buf, 0xc6)
+		m.buf = appendUint32(m.buf, uint32(n))
+	}
+	m.buf = append(m.buf, b...)
+}
+
+func (m *msgpackWriter) writeInt(i int64) {
+	switch {
+	case i >= 0:
+		m.writeUint(uint64(i))
+	case i >= -32:
+		m.buf = append(m.buf, byte(i))
+	case i >= math.MinInt8:
+		m.buf = append(m.buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		m.buf = append(m.buf, 0xd1)
+		m.buf = appendUint16(m.buf, uint16(i))
+	case i >= math.MinInt32:
+		m.buf = append(m.buf, 0xd2)
+		m.buf = appendUint32(m.buf, uint32(i))
+	default:
+		m.buf = append(m.buf, 0xd3)
+		m.buf = appendUint64(m.buf, uint64(i))
+	}
+}
+
+func (m *msgpackWriter) writeUint(i uint64) {
+	switch {
+	case i <= 127:
+		m.buf = append(m.buf, byte(i))
+	case i <= math.MaxUint8:
+		m.buf = append(m.buf, 0xcc, byte(i))
+	case i <= math.MaxUint16:
+		m.buf = append(m.buf, 0xcd)
+		m.buf = appendUint16(m.buf, uint16(i))
+	case i <= math.MaxUint32:
+		m.buf = append(m.buf, 0xce)
+		m.buf = appendUint32(m.buf, uint32(i))
+	default:
+		m.buf = append(m.buf, 0xcf)
+		m.buf = appendUint64(m.buf, i)
+	}
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func msgpack(t *testing.T, v interface{}) []byte {
+	buf := &bytes.Buffer{}
+	if e := new(MsgpackEncoder).Encode(buf, v); e != nil {
+		t.Fatalf("%#v: %v", v, e)
+	}
+	return buf.Bytes()
+}
+
+func TestMsgpackScalars(t *testing.T) {
+	for _, c := range []struct {
+		v        interface{}
+		expected string
+	}{
+		{nil, "c0"},
+		{true, "c3"},
+		{false, "c2"},
+		{0, "00"},
+		{127, "7f"},
+		{128, "cc80"},
+		{255, "ccff"},
+		{256, "cd0100"},
+		{65535, "cdffff"},
+		{65536, "ce00010000"},
+		{uint64(1) << 32, "cf0000000100000000"},
+		{-1, "ff"},
+		{-32, "e0"},
+		{-33, "d0df"},
+		{-128, "d080"},
+		{-129, "d1ff7f"},
+		{-32768, "d18000"},
+		{-32769, "d2ffff7fff"},
+		{int64(-1) << 31, "d280000000"},
+		{int64(-1)<<31 - 1, "d3ffffffff7fffffff"},
+		{float32(1.5), "ca3fc00000"},
+		{1.5, "cb3ff8000000000000"},
+		{"", "a0"},
+		{"a", "a161"},
+		{[]byte{}, "c400"},
+		{[]byte{1, 2}, "c4020102"},
+		{[2]byte{1, 2}, "c4020102"},
+		{[]int{1, -1}, "9201ff"},
+		{[]int(nil), "c0"},
+		{map[string]int(nil), "c0"},
+		{map[string]int{"a": 1}, "81a16101"},
+	} {
+		if actual := hex.EncodeToString(msgpack(t, c.v)); actual != c.expected {
+			t.Errorf("%#v: %s, expected %s", c.v, actual, c.expected)
+		}
+	}
+}
+
+// headers of sizes around the fix, 8 and 16 bits boundaries
+func TestMsgpackLengths(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		v      interface{}
+		header string
+	}{
+		{"fixstr 31", strings.Repeat("a", 31), "bf"},
+		{"str8 32", strings.Repeat("a", 32), "d920"},
+		{"str8 255", strings.Repeat("a", 255), "d9ff"},
+		{"str16 256", strings.Repeat("a", 256), "da0100"},
+		{"str32 65536", strings.Repeat("a", 65536), "db00010000"},
+		{"bin8 255", make([]byte, 255), "c4ff"},
+		{"bin16 256", make([]byte, 256), "c50100"},
+		{"bin32 65536", make([]byte, 65536), "c600010000"},
+		{"fixarray 15", make([]bool, 15), "9f"},
+		{"array16 16", make([]bool, 16), "dc0010"},
+		{"array32 65536", make([]bool, 65536), "dd00010000"},
+		{"fixmap 15", intMap(15), "8f"},
+		{"map16 16", intMap(16), "de0010"},
+		{"map32 65536", intMap(65536), "df00010000"},
+	} {
+		b := msgpack(t, c.v)
+		if actual := hex.EncodeToString(b[:len(c.header)/2]); actual != c.header {
+			t.Errorf("%s: header %s, expected %s", c.name, actual, c.header)
+		}
+	}
+}
+
+func intMap(n int) map[int]bool {
+	m := make(map[int]bool, n)
+	for i := 0; i < n; i++ {
+		m[i] = true
+	}
+	return m
+}
+
+func TestMsgpackStruct(t *testing.T) {
+	type inner struct {
+		N int `msgpack:"n"`
+	}
+	v := struct {
+		A      string `json:"a"`
+		B      int    `msgpack:"b" json:"x"`
+		C      string `json:"c,omitempty"`
+		D      int    `json:"-"`
+		hidden int
+		Inner  *inner
+	}{A: "x", B: -1, D: 1, hidden: 1, Inner: &inner{N: 1}}
+
+	// {"a": "x", "b": -1, "Inner": {"n": 1}}
+	expected := "83" + "a161a178" + "a162ff" + "a5496e6e6572" + "81a16e01"
+	if actual := hex.EncodeToString(msgpack(t, v)); actual != expected {
+		t.Errorf("struct: %s, expected %s", actual, expected)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// optionally implemented by Interceptor and AroundInterceptor to be ordered relatively to other interceptors of the chain
+// constraints win over priorities, names not registered in the chain are ignored
+//
+//	eg: func (a *Audit) Name() string     { return "audit" }
+//		func (a *Audit) Before() []string { return nil }
+//		func (a *Audit) After() []string  { return []string{"auth"} }
+type OrderedInterceptor interface {
+	// name referred by other interceptors, the type name if empty, eg: "*app.Audit"
+	Name() string
+
+	// interceptors called after this one, ie: wrapped by it
+	Before() []string
+
+	// interceptors called before this one
+	After() []string
+}
+
+// name of an interceptor referred by constraints, listed by Routes and Dump
+func interceptorName(i interface{}) string {
+	if o, ok := i.(OrderedInterceptor); ok && o.Name() != "" {
+		return o.Name()
+	}
+	return fmt.Sprintf("%T", i)
+}
+
+// sort spores of the same kind by name, as spores are discovered in no particular order
+func sortSpores(spores []interface{}) {
+	sort.SliceStable(spores, func(i, j int) bool {
+		return interceptorName(spores[i]) < interceptorName(spores[j])
+	})
+}
+
+// interceptors ordered by constraints, then by priority, then by registration
+// interceptors are ordered by priority only if the constraints form a cycle
+func orderInterceptors(registered []interceptorEntry) ([]interceptorEntry, error) {
+	byPriority := append([]interceptorEntry{}, registered...)
+	sort.SliceStable(byPriority, func(i, j int) bool {
+		return byPriority[i].priority < byPriority[j].priority
+	})
+
+	named := map[string][]int{}
+	for i, entry := range byPriority {
+		named[entry.name] = append(named[entry.name], i)
+	}
+	// successors and predecessors of each interceptor by index
+	next := make([][]int, len(byPriority))
+	prev := make([][]int, len(byPriority))
+	link := func(from int, to int) {
+		if from != to {
+			next[from] = append(next[from], to)
+			prev[to] = append(prev[to], from)
+		}
+	}
+	for i, entry := range byPriority {
+		for _, name := range entry.before {
+			for _, j := range named[name] {
+				link(i, j)
+			}
+		}
+		for _, name := range entry.after {
+			for _, j := range named[name] {
+				link(j, i)
+			}
+		}
+	}
+
+	// the first interceptor by priority whose predecessors are placed is placed next
+	pending := make([]int, len(byPriority))
+	for i := range byPriority {
+		pending[i] = len(prev[i])
+	}
+	placed := make([]bool, len(byPriority))
+	ordered := make([]interceptorEntry, 0, len(byPriority))
+	for len(ordered) < len(byPriority) {
+		found := -1
+		for i := range byPriority {
+			if !placed[i] && pending[i] == 0 {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return byPriority, fmt.Errorf("interceptor order constraints form a cycle: %s", interceptorCycle(byPriority, prev, placed))
+		}
+		placed[found] = true
+		ordered = append(ordered, byPriority[found])
+		for _, j := range next[found] {
+			pending[j]--
+		}
+	}
+	return ordered, nil
+}
+
+// every interceptor left has a predecessor left, following them backwards leads to a cycle
+//
+//	eg: "auth -> audit -> auth"
+func interceptorCycle(entries []interceptorEntry, prev [][]int, placed []bool) string {
+	current := 0
+	for placed[current] {
+		current++
+	}
+	visited := map[int]int{}
+	var path []int
+	for {
+		if at, ok := visited[current]; ok {
+			path = path[at:]
+			break
+		}
+		visited[current] = len(path)
+		path = append(path, current)
+		for _, p := range prev[current] {
+			if !placed[p] {
+				current = p
+				break
+			}
+		}
+	}
+
+	// predecessors were followed, the cycle is reported in calling order from its first interceptor by priority
+	first := 0
+	for i, p := range path {
+		if p < path[first] {
+			first = i
+		}
+	}
+	names := make([]string, 0, len(path)+1)
+	for i := 0; i <= len(path); i++ {
+		names = append(names, entries[path[(first-i+len(path))%len(path)]].name)
+	}
+	return strings.Join(names, " -> ")
+}
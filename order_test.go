@@ -0,0 +1,175 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type orderedInterceptor struct {
+	name     string
+	priority int
+	before   []string
+	after    []string
+	trace    *[]string
+}
+
+func (o *orderedInterceptor) Name() string {
+	return o.name
+}
+
+func (o *orderedInterceptor) Before() []string {
+	return o.before
+}
+
+func (o *orderedInterceptor) After() []string {
+	return o.after
+}
+
+func (o *orderedInterceptor) Priority() int {
+	return o.priority
+}
+
+func (o *orderedInterceptor) Intercept(ctx *RequestCtx, properties map[string]interface{}) (InterceptorAction, interface{}) {
+	if o.trace != nil {
+		*o.trace = append(*o.trace, o.name)
+	}
+	return Continue, nil
+}
+
+func chainOf(interceptors ...*orderedInterceptor) *InterceptorChain {
+	chain := NewInterceptorChain()
+	for _, i := range interceptors {
+		chain.AddInterceptor(i)
+	}
+	return chain
+}
+
+func chainNames(chain *InterceptorChain) []string {
+	var names []string
+	for _, entry := range chain.snapshot() {
+		names = append(names, entry.name)
+	}
+	return names
+}
+
+func TestInterceptorConstraints(t *testing.T) {
+	for _, c := range []struct {
+		interceptors []*orderedInterceptor
+		expected     []string
+	}{
+		// priorities, equal ones keep the registration order
+		{[]*orderedInterceptor{{name: "b", priority: 1}, {name: "a", priority: 0}, {name: "c", priority: 1}}, []string{"a", "b", "c"}},
+		// constraints win over priorities
+		{[]*orderedInterceptor{{name: "auth", priority: 0}, {name: "audit", priority: -1, after: []string{"auth"}}}, []string{"auth", "audit"}},
+		{[]*orderedInterceptor{{name: "auth", priority: 0}, {name: "cors", priority: 1, before: []string{"auth"}}}, []string{"cors", "auth"}},
+		// transitive constraints
+		{[]*orderedInterceptor{
+			{name: "c", priority: -2, after: []string{"b"}},
+			{name: "b", priority: -1, after: []string{"a"}},
+			{name: "a", priority: 0},
+			{name: "x", priority: -3},
+		}, []string{"x", "a", "b", "c"}},
+		// unknown names are ignored
+		{[]*orderedInterceptor{{name: "a", priority: 1, after: []string{"missing"}}, {name: "b", priority: 2, before: []string{"missing"}}}, []string{"a", "b"}},
+	} {
+		chain := chainOf(c.interceptors...)
+		if got := chainNames(chain); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%v, expected %v", got, c.expected)
+		}
+		if e := chain.Freeze(); e != nil {
+			t.Error(e)
+		}
+	}
+}
+
+func TestInterceptorCycle(t *testing.T) {
+	for _, c := range []struct {
+		interceptors []*orderedInterceptor
+		cycle        string
+	}{
+		{[]*orderedInterceptor{{name: "a", before: []string{"b"}}, {name: "b", before: []string{"a"}}}, "a -> b -> a"},
+		{[]*orderedInterceptor{{name: "b", after: []string{"a"}}, {name: "a", after: []string{"b"}}}, "b -> a -> b"},
+		{[]*orderedInterceptor{
+			{name: "x", priority: -1},
+			{name: "a", before: []string{"b"}},
+			{name: "b", before: []string{"c"}},
+			{name: "c", before: []string{"a"}},
+		}, "a -> b -> c -> a"},
+	} {
+		chain := chainOf(c.interceptors...)
+		e := chain.Freeze()
+		if e == nil || !strings.HasSuffix(e.Error(), "form a cycle: "+c.cycle) {
+			t.Errorf("%v, expected cycle %s", e, c.cycle)
+		}
+		// the order falls back to priorities
+		if got := chainNames(chain); len(got) != len(c.interceptors) {
+			t.Errorf("%v", got)
+		}
+	}
+}
+
+func TestFrozenChain(t *testing.T) {
+	chain := chainOf(&orderedInterceptor{name: "a"})
+	if e := chain.Freeze(); e != nil {
+		t.Fatal(e)
+	}
+	defer func() {
+		if err := recover(); err == nil || !strings.Contains(err.(string), "frozen, b can not be added") {
+			t.Errorf("%v", err)
+		}
+	}()
+	chain.AddInterceptor(&orderedInterceptor{name: "b"})
+}
+
+func TestCycleFailsMapping(t *testing.T) {
+	s := newTestServer()
+	s.GET("/", text("ok"))
+	s.AddInterceptor(&orderedInterceptor{name: "a", after: []string{"b"}})
+	s.AddInterceptor(&orderedInterceptor{name: "b", after: []string{"a"}})
+	if len(s.mappingErrors) != 1 || !strings.Contains(s.mappingErrors[0].Error(), "a -> b -> a") {
+		t.Errorf("%v", s.mappingErrors)
+	}
+}
+
+func TestInterceptorsOrderOnRequest(t *testing.T) {
+	var trace []string
+	s := newTestServer()
+	s.GET("/", text("ok"))
+	s.AddInterceptor(&orderedInterceptor{name: "audit", priority: -10, after: []string{"auth"}, trace: &trace})
+	s.AddInterceptor(&orderedInterceptor{name: "auth", priority: 0, trace: &trace})
+	s.AddInterceptor(&orderedInterceptor{name: "recovery", priority: -20, trace: &trace})
+
+	serve(s, "GET", "/", nil)
+	if expected := []string{"recovery", "auth", "audit"}; !reflect.DeepEqual(trace, expected) {
+		t.Errorf("%v, expected %v", trace, expected)
+	}
+	expected := []string{"-20 recovery", "0 auth", "-10 audit after [auth]"}
+	if dump := s.handlers.interceptorChain.Dump(); !reflect.DeepEqual(dump, expected) {
+		t.Errorf("%v, expected %v", dump, expected)
+	}
+}
+
+func TestSortInterceptors(t *testing.T) {
+	// spores are discovered in no particular order, ties are ordered by name
+	for _, discovered := range [][]interface{}{
+		{&orderedInterceptor{name: "b"}, &orderedInterceptor{name: "a"}, blockingInterceptor("c"), &orderedInterceptor{name: ""}},
+		{blockingInterceptor("c"), &orderedInterceptor{name: ""}, &orderedInterceptor{name: "a"}, &orderedInterceptor{name: "b"}},
+	} {
+		sortSpores(discovered)
+		chain := NewInterceptorChain()
+		for _, i := range discovered {
+			chain.AddInterceptor(i.(Interceptor))
+		}
+		expected := []string{"*kinoko_web.orderedInterceptor", "a", "b", "kinoko_web.blockingInterceptor"}
+		if got := chainNames(chain); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%v, expected %v", got, expected)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+type ErrorConfig struct {
+	// send the panic message and stack trace to clients, never enable it in production
+	ExposeStacktrace bool `inject:"kinoko.web.errors.expose-stacktrace:false"`
+}
+
+// notified when a handler panics, register it as a kinoko spore, eg: forward to an error reporting service
+// the response is already handled by the framework
+type PanicHandler interface {
+	HandlePanic(ctx *RequestCtx, incident string, err interface{}, stack []byte)
+}
+
+// returned to the client and logged to find the stack trace of a panic
+const IncidentHeader = "X-Incident-Id"
+
+func newIncidentID() string {
+	b := make([]byte, 8)
+	if _, e := rand.Read(b); e != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// log the panic with its stack, notify the panic handlers and respond 500 with the incident id
+func (c *RequestHandler) recoverPanic(ctx *RequestCtx, wr http.ResponseWriter, err interface{}) {
+	stack := debug.Stack()
+	incident := newIncidentID()
+	logger.Error("Panic recovered, incident", incident, "-", err, "\n"+string(stack))
+
+	for _, h := range c.panicHandlers {
+		c.notifyPanic(h, ctx, incident, err, stack)
+	}
+
+	details := map[string]string{"incident": incident}
+	message := "internal server error, incident " + incident
+	if c.exposeStacktrace {
+		message = fmt.Sprint(err)
+		details["stacktrace"] = string(stack)
+	}
+	wr.Header().Set(IncidentHeader, incident)
+	renderError(wr, ctx.Request, InternalServerError(message).WithCode("INTERNAL_ERROR").WithDetails(details))
+}
+
+// a panicking handler must not break the recovery
+func (c *RequestHandler) notifyPanic(h PanicHandler, ctx *RequestCtx, incident string, err interface{}, stack []byte) {
+	defer func() {
+		if e := recover(); e != nil {
+			logger.Error("Panic handler failed, incident", incident, "-", e)
+		}
+	}()
+	h.HandlePanic(ctx, incident, err, stack)
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// keeps the incidents it was notified of
+type recordingPanicHandler struct {
+	incidents []string
+	errs      []interface{}
+}
+
+func (h *recordingPanicHandler) HandlePanic(ctx *RequestCtx, incident string, err interface{}, stack []byte) {
+	h.incidents = append(h.incidents, incident)
+	h.errs = append(h.errs, err)
+}
+
+type panickingPanicHandler struct{}
+
+func (panickingPanicHandler) HandlePanic(ctx *RequestCtx, incident string, err interface{}, stack []byte) {
+	panic("reporting failed")
+}
+
+func panicServer() (*HttpServer, *recordingPanicHandler) {
+	s := newTestServer()
+	h := &recordingPanicHandler{}
+	s.handlers.panicHandlers = []PanicHandler{panickingPanicHandler{}, h}
+	s.GET("/panic", func(ctx *RequestCtx) interface{} {
+		panic("secret connection string")
+	})
+	return s, h
+}
+
+func TestPanicHidesStacktrace(t *testing.T) {
+	s, h := panicServer()
+	w := serve(s, "GET", "/panic", nil)
+
+	incident := w.Header().Get(IncidentHeader)
+	if w.Code != http.StatusInternalServerError || incident == "" {
+		t.Fatalf("%d %q", w.Code, incident)
+	}
+	if strings.Contains(w.Body.String(), "secret") || strings.Contains(w.Body.String(), "goroutine") {
+		t.Errorf("panic exposed: %s", w.Body.String())
+	}
+	var p problem
+	if e := json.Unmarshal(w.Body.Bytes(), &p); e != nil {
+		t.Fatal(e)
+	}
+	if p.Code != "INTERNAL_ERROR" || p.Detail != "internal server error, incident "+incident {
+		t.Errorf("%+v", p)
+	}
+
+	// the panic handlers are notified even if one of them panics
+	if len(h.incidents) != 1 || h.incidents[0] != incident || h.errs[0] != "secret connection string" {
+		t.Errorf("%v %v", h.incidents, h.errs)
+	}
+}
+
+func TestPanicExposesStacktrace(t *testing.T) {
+	s, _ := panicServer()
+	s.handlers.exposeStacktrace = true
+	w := serve(s, "GET", "/panic", http.Header{"Accept": {"text/html"}})
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "secret connection string") || !strings.Contains(w.Body.String(), "goroutine") {
+		t.Errorf("%d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestIncidentIDs(t *testing.T) {
+	a, b := newIncidentID(), newIncidentID()
+	if len(a) != 16 || a == b {
+		t.Errorf("%s %s", a, b)
+	}
+}
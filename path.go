@@ -0,0 +1,109 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// policy of resolving request paths
+//
+//	kinoko:
+//	  web:
+//	    router:
+//	      strict: false
+//	      redirect-trailing-slash: true
+//	      redirect-clean-path: true
+//	      case-insensitive: false
+//
+// strict mode matches the path as it is received, no cleaning or redirection is done
+// otherwise dot segments and duplicate slashes are resolved, the client is redirected to the clean path
+// if redirect-clean-path is set, and a path differing from a mapped route by a trailing slash is
+// redirected to the route if redirect-trailing-slash is set
+//
+// redirection responds 301 for GET and HEAD, 308 for other methods so the body is resent
+type RouterConfig struct {
+	Strict                bool `inject:"kinoko.web.router.strict:false"`
+	RedirectTrailingSlash bool `inject:"kinoko.web.router.redirect-trailing-slash:true"`
+	RedirectCleanPath     bool `inject:"kinoko.web.router.redirect-clean-path:true"`
+	CaseInsensitive       bool `inject:"kinoko.web.router.case-insensitive:false"`
+}
+
+// used if the router is not configured
+var defaultRouterConfig = RouterConfig{RedirectTrailingSlash: true, RedirectCleanPath: true}
+
+func (c *RequestHandler) routerConfig() *RouterConfig {
+	if c.router == nil {
+		return &defaultRouterConfig
+	}
+	return c.router
+}
+
+// resolve dot segments and duplicate slashes of an escaped path, the trailing slash is kept
+// escaped dots are resolved too, other escapes are preserved
+//
+//	eg: "/a//b/./c/../%2e%2e/d/" => "/a/d/"
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	cleaned := make([]string, 0, len(segments))
+	for _, seg := range segments {
+		switch unescapePath(seg) {
+		case "", ".":
+		case "..":
+			if len(cleaned) > 0 {
+				cleaned = cleaned[:len(cleaned)-1]
+			}
+		default:
+			cleaned = append(cleaned, seg)
+		}
+	}
+	result := "/" + strings.Join(cleaned, "/")
+	if len(cleaned) > 0 && segments[len(segments)-1] == "" {
+		result += "/"
+	}
+	return result
+}
+
+// unescape a part of the escaped path, invalid escapes are kept as they are
+func unescapePath(p string) string {
+	if strings.IndexByte(p, '%') < 0 {
+		return p
+	}
+	if s, e := url.PathUnescape(p); e == nil {
+		return s
+	}
+	return p
+}
+
+// the path differing by a trailing slash, empty for the root
+func toggleTrailingSlash(p string) string {
+	if p == "/" {
+		return ""
+	}
+	if strings.HasSuffix(p, "/") {
+		return p[:len(p)-1]
+	}
+	return p + "/"
+}
+
+// redirect to the canonical path keeping the query
+func redirectPath(wr http.ResponseWriter, r *http.Request, p string) {
+	code := http.StatusPermanentRedirect
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		code = http.StatusMovedPermanently
+	}
+	if r.URL.RawQuery != "" {
+		p += "?" + r.URL.RawQuery
+	}
+	wr.Header().Set("Location", p)
+	wr.WriteHeader(code)
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCleanPath(t *testing.T) {
+	for _, c := range []struct {
+		path     string
+		expected string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"//", "/"},
+		{"/a/b", "/a/b"},
+		{"/a/b/", "/a/b/"},
+		{"/a//b", "/a/b"},
+		{"/a/./b", "/a/b"},
+		{"/a/../b", "/b"},
+		{"/../a", "/a"},
+		{"/a/b/..", "/a"},
+		{"/a/b/../", "/a/"},
+		{"/a/%2e%2E/b", "/b"},
+		{"/a/%2e/b", "/a/b"},
+		{"/a%2Fb/c", "/a%2Fb/c"},
+		{"/a/%zz/..", "/a"},
+		{"/a//b/./c/../%2e%2e/d/", "/a/d/"},
+	} {
+		if actual := cleanPath(c.path); actual != c.expected {
+			t.Errorf("%q: %q, expected %q", c.path, actual, c.expected)
+		}
+	}
+}
+
+func pathServer(config *RouterConfig) *HttpServer {
+	s := newTestServer()
+	s.handlers.router = config
+	s.GET("/users", text("users"))
+	s.POST("/users", text("created"))
+	s.GET("/docs/", text("docs"))
+	s.GET("/files/:name", func(ctx *RequestCtx) interface{} {
+		return ctx.PathVariable["name"]
+	})
+	s.GET("/static/*path", func(ctx *RequestCtx) interface{} {
+		return ctx.PathVariable["path"]
+	})
+	return s
+}
+
+func TestPathRedirects(t *testing.T) {
+	s := pathServer(nil)
+	for _, c := range []struct {
+		method   string
+		url      string
+		code     int
+		location string
+	}{
+		{"GET", "/users/", http.StatusMovedPermanently, "/users"},
+		{"HEAD", "/users/", http.StatusMovedPermanently, "/users"},
+		{"POST", "/users/", http.StatusPermanentRedirect, "/users"},
+		{"GET", "/docs", http.StatusMovedPermanently, "/docs/"},
+		{"GET", "/a/../users?page=2", http.StatusMovedPermanently, "/users?page=2"},
+		{"POST", "//users", http.StatusPermanentRedirect, "/users"},
+		{"PUT", "/users", http.StatusMethodNotAllowed, ""},
+		{"PUT", "/users/", http.StatusNotFound, ""},
+		{"GET", "/missing/", http.StatusNotFound, ""},
+		{"GET", "/users", http.StatusOK, ""},
+	} {
+		w := serve(s, c.method, c.url, nil)
+		if w.Code != c.code || w.Header().Get("Location") != c.location {
+			t.Errorf("%s %s: %d %q, expected %d %q", c.method, c.url, w.Code, w.Header().Get("Location"), c.code, c.location)
+		}
+	}
+}
+
+func TestPathPolicies(t *testing.T) {
+	for _, c := range []struct {
+		config   RouterConfig
+		url      string
+		code     int
+		location string
+	}{
+		// the clean path is served without redirection
+		{RouterConfig{RedirectTrailingSlash: true}, "/a/../users", http.StatusOK, ""},
+		{RouterConfig{RedirectCleanPath: true}, "/users/", http.StatusNotFound, ""},
+		{RouterConfig{Strict: true, RedirectCleanPath: true, RedirectTrailingSlash: true}, "/a/../users", http.StatusNotFound, ""},
+		{RouterConfig{Strict: true, RedirectCleanPath: true, RedirectTrailingSlash: true}, "/users/", http.StatusNotFound, ""},
+		{RouterConfig{CaseInsensitive: true}, "/USERS", http.StatusOK, ""},
+		{RouterConfig{}, "/USERS", http.StatusNotFound, ""},
+		{RouterConfig{CaseInsensitive: true, RedirectTrailingSlash: true}, "/Docs", http.StatusMovedPermanently, "/Docs/"},
+	} {
+		w := serve(pathServer(&c.config), "GET", c.url, nil)
+		if w.Code != c.code || w.Header().Get("Location") != c.location {
+			t.Errorf("%+v %s: %d %q, expected %d %q", c.config, c.url, w.Code, w.Header().Get("Location"), c.code, c.location)
+		}
+	}
+}
+
+func TestEscapedPathVariables(t *testing.T) {
+	s := pathServer(&RouterConfig{CaseInsensitive: true})
+	for url, expected := range map[string]string{
+		"/files/a%2Fb":         "a/b",
+		"/files/a%20b":         "a b",
+		"/FILES/Readme":        "Readme",
+		"/static/a%2Fb/c":      "a/b/c",
+		"/static/css/site.css": "css/site.css",
+	} {
+		if w := serve(s, "GET", url, nil); w.Code != http.StatusOK || w.Body.String() != expected {
+			t.Errorf("%s: %d %q, expected %q", url, w.Code, w.Body.String(), expected)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// constraint of path variables used in patterns, register it as a kinoko spore
+// the constraint is checked while matching, so a segment which does not match falls through to sibling routes
+//
+//	eg: "/users/:id<int>", "/posts/:slug<[a-z0-9-]+>", "/files/:uuid<uuid>"
+//
+// a constraint not registered is compiled as a regular expression matching the whole segment
+type PathConstraint interface {
+	Constraint() string
+	Match(segment string) bool
+}
+
+// path constraint implemented by a function
+type PathConstraintFunc struct {
+	Name string
+	Func func(segment string) bool
+}
+
+func (c *PathConstraintFunc) Constraint() string {
+	return c.Name
+}
+
+func (c *PathConstraintFunc) Match(segment string) bool {
+	return c.Func(segment)
+}
+
+var pathConstraints = struct {
+	sync.RWMutex
+	constraints map[string]PathConstraint
+}{constraints: map[string]PathConstraint{}}
+
+func init() {
+	for _, c := range []*PathConstraintFunc{
+		{"int", func(s string) bool { _, e := strconv.ParseInt(s, 10, 64); return e == nil }},
+		{"uint", func(s string) bool { _, e := strconv.ParseUint(s, 10, 64); return e == nil }},
+		{"float", func(s string) bool { _, e := strconv.ParseFloat(s, 64); return e == nil }},
+		{"alpha", regexp.MustCompile("^[a-zA-Z]+$").MatchString},
+		{"alnum", regexp.MustCompile("^[a-zA-Z0-9]+$").MatchString},
+		{"hex", regexp.MustCompile("^[a-fA-F0-9]+$").MatchString},
+		{"uuid", regexp.MustCompile("^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}$").MatchString},
+	} {
+		RegisterPathConstraint(c)
+	}
+}
+
+// register a constraint, any constraint with the same name is replaced
+// constraints must be registered before the routes using them are mapped
+func RegisterPathConstraint(constraint PathConstraint) {
+	pathConstraints.Lock()
+	pathConstraints.constraints[constraint.Constraint()] = constraint
+	pathConstraints.Unlock()
+}
+
+// resolve a registered constraint by name, or compile it as a regular expression
+func compileConstraint(spec string) (func(string) bool, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	pathConstraints.RLock()
+	c := pathConstraints.constraints[spec]
+	pathConstraints.RUnlock()
+	if c != nil {
+		return c.Match, nil
+	}
+	r, e := regexp.Compile("^(?:" + spec + ")$")
+	if e != nil {
+		return nil, fmt.Errorf("invalid path constraint <%s>: %v", spec, e)
+	}
+	return r.MatchString, nil
+}
+
+type segmentKind int
+
+const (
+	staticSegment segmentKind = iota
+	paramSegment
+	catchAllSegment
+)
+
+type segment struct {
+	kind segmentKind
+
+	// static text or placeholder name
+	value      string
+	constraint string
+	optional   bool
+}
+
+// ":id<int>?" => optional param "id" constrained by "int"
+func parseSegment(s string) segment {
+	if len(s) == 0 || (s[0] != ':' && s[0] != '*') {
+		return segment{kind: staticSegment, value: s}
+	}
+	seg := segment{kind: paramSegment}
+	if s[0] == '*' {
+		seg.kind = catchAllSegment
+	}
+	s = s[1:]
+	if i := strings.IndexByte(s, '<'); i >= 0 {
+		end := strings.LastIndexByte(s, '>')
+		if end > i {
+			seg.constraint = s[i+1 : end]
+			seg.optional = s[end+1:] == "?"
+			seg.value = s[:i]
+			return seg
+		}
+	}
+	if strings.HasSuffix(s, "?") {
+		seg.optional = true
+		s = s[:len(s)-1]
+	}
+	seg.value = s
+	return seg
+}
+
+// the segment without the optional mark
+func (s segment) String() string {
+	switch s.kind {
+	case paramSegment, catchAllSegment:
+		mark := ":"
+		if s.kind == catchAllSegment {
+			mark = "*"
+		}
+		if s.constraint != "" {
+			return mark + s.value + "<" + s.constraint + ">"
+		}
+		return mark + s.value
+	default:
+		return s.value
+	}
+}
+
+// "/users/:id/*path" => [id path]
+func patternPlaceholders(pattern string) []string {
+	var placeholders []string
+	for _, raw := range strings.Split(pattern, "/") {
+		if seg := parseSegment(raw); seg.kind != staticSegment {
+			placeholders = append(placeholders, seg.value)
+		}
+	}
+	return placeholders
+}
+
+// expand optional segments into every combination, the leading slash is removed
+//
+//	eg: "/users/:id?" => ["users/:id", "users"]
+func expandOptional(pattern string) []string {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return []string{""}
+	}
+	patterns := [][]string{{}}
+	for _, raw := range strings.Split(pattern, "/") {
+		seg := parseSegment(raw)
+		var expanded [][]string
+		for _, p := range patterns {
+			expanded = append(expanded, append(append([]string{}, p...), seg.String()))
+			if seg.optional {
+				expanded = append(expanded, p)
+			}
+		}
+		patterns = expanded
+	}
+	result := make([]string, len(patterns))
+	for i, p := range patterns {
+		result[i] = strings.Join(p, "/")
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSegment(t *testing.T) {
+	for raw, expected := range map[string]segment{
+		"users":            {kind: staticSegment, value: "users"},
+		":id":              {kind: paramSegment, value: "id"},
+		":id?":             {kind: paramSegment, value: "id", optional: true},
+		":id<int>":         {kind: paramSegment, value: "id", constraint: "int"},
+		":id<int>?":        {kind: paramSegment, value: "id", constraint: "int", optional: true},
+		":slug<[a-z>]+>":   {kind: paramSegment, value: "slug", constraint: "[a-z>]+"},
+		"*path":            {kind: catchAllSegment, value: "path"},
+		"*path<.+\\.json>": {kind: catchAllSegment, value: "path", constraint: ".+\\.json"},
+	} {
+		if seg := parseSegment(raw); seg != expected {
+			t.Errorf("%s: %+v, expected %+v", raw, seg, expected)
+		}
+	}
+}
+
+func TestExpandOptional(t *testing.T) {
+	for pattern, expected := range map[string][]string{
+		"/":                          {""},
+		"/users":                     {"users"},
+		"/users/:id?":                {"users/:id", "users"},
+		"/:lang<[a-z]{2}>?/docs/:p?": {":lang<[a-z]{2}>/docs/:p", ":lang<[a-z]{2}>/docs", "docs/:p", "docs"},
+	} {
+		if actual := expandOptional(pattern); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: %q, expected %q", pattern, actual, expected)
+		}
+	}
+}
+
+func TestPathConstraints(t *testing.T) {
+	s := newTestServer()
+	s.GET("/users/:id<int>", text("by id"))
+	s.GET("/users/:name<alpha>", text("by name"))
+	s.GET("/users/:other", text("other"))
+	s.GET("/files/:uuid<uuid>", text("file"))
+	s.GET("/posts/:slug<[a-z0-9-]+>", text("post"))
+
+	for url, expected := range map[string]string{
+		"/users/42":  "by id",
+		"/users/-1":  "by id",
+		"/users/abc": "by name",
+		"/users/a_1": "other",
+		"/posts/a-1": "post",
+		"/files/0b5c5f3e-2d4e-4f1b-9b8a-1a2b3c4d5e6f": "file",
+	} {
+		if w := serve(s, "GET", url, nil); w.Body.String() != expected {
+			t.Errorf("%s: %d %q, expected %q", url, w.Code, w.Body.String(), expected)
+		}
+	}
+	for _, url := range []string{"/posts/A-1", "/files/abc"} {
+		if w := serve(s, "GET", url, nil); w.Code != 404 {
+			t.Errorf("%s: %d", url, w.Code)
+		}
+	}
+}
+
+func TestRegisterPathConstraint(t *testing.T) {
+	RegisterPathConstraint(&PathConstraintFunc{"lower", func(s string) bool { return s == strings.ToLower(s) }})
+	defer func() {
+		pathConstraints.Lock()
+		delete(pathConstraints.constraints, "lower")
+		pathConstraints.Unlock()
+	}()
+
+	s := newTestServer()
+	s.GET("/tags/:tag<lower>", text("tag"))
+	if w := serve(s, "GET", "/tags/go", nil); w.Body.String() != "tag" {
+		t.Errorf("lower: %d", w.Code)
+	}
+	if w := serve(s, "GET", "/tags/Go", nil); w.Code != 404 {
+		t.Errorf("upper: %d", w.Code)
+	}
+	if _, e := compileConstraint("[a-z"); e == nil {
+		t.Error("invalid regular expression compiled")
+	}
+}
+
+func TestOptionalSegments(t *testing.T) {
+	s := newTestServer()
+	s.GET("/docs/:lang<[a-z]{2}>?/:page?", func(ctx *RequestCtx) interface{} {
+		return ctx.PathVariable["lang"] + "|" + ctx.PathVariable["page"]
+	})
+
+	for url, expected := range map[string]string{
+		"/docs":          "|",
+		"/docs/en":       "en|",
+		"/docs/en/intro": "en|intro",
+		"/docs/intro":    "|intro",
+	} {
+		if w := serve(s, "GET", url, nil); w.Body.String() != expected {
+			t.Errorf("%s: %d %q, expected %q", url, w.Code, w.Body.String(), expected)
+		}
+	}
+}
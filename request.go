@@ -7,11 +7,8 @@
 package kinoko_web
 
 import (
-	"encoding/json"
-	"io/ioutil"
 	"mime/multipart"
 	"net/http"
-	"net/url"
 	"strings"
 )
 
@@ -37,6 +34,16 @@ type RequestCtx struct {
 
 	//customized properties by resolving request with RequestResolver
 	Properties map[interface{}]interface{}
+
+	// properties of the mapped route
+	properties map[string]interface{}
+
+	// handler and virtual host serving the request, used to generate urls
+	handlers *RequestHandler
+	host     *virtualHost
+
+	// set by the auth interceptor, see Principal
+	principal *Principal
 }
 
 func NewRequestCtx(queryString map[string][]string, pathVariable map[string]string, request *http.Request, form *multipart.Form, responseWriter http.ResponseWriter) *RequestCtx {
@@ -55,58 +62,22 @@ func NewRequestCtx(queryString map[string][]string, pathVariable map[string]stri
 	}
 }
 
+// decode the body into dst by the BodyDecoder of its content type and validate it
+// returns *ErrUnsupportedMediaType if no decoder is registered, *BindingError if dst is invalid
 func (c *RequestCtx) ParseBody(dst interface{}) error {
 	ct := c.Request.Header.Get("Content-Type")
-	contentType := strings.Split(ct, ";")[0]
-	if strings.EqualFold(contentType, "application/json") {
-		bytes, e := ioutil.ReadAll(c.Request.Body) //Note that ReadAll is not safe for oom attack
-		defer func() {
-			_ = c.Request.Body.Close()
-		}()
-
-		if e != nil {
-			return e
-		}
-
-		e = json.Unmarshal(bytes, dst)
-		if e != nil {
-			return e
-		}
-		return nil
-	}
-
-	if strings.EqualFold(contentType, "application/x-www-form-urlencoded") {
-		bytes, e := ioutil.ReadAll(c.Request.Body)
-		defer func() {
-			_ = c.Request.Body.Close()
-		}()
-
-		if e != nil {
-			return e
-		}
-
-		values, e := url.ParseQuery(string(bytes))
-
-		if e != nil {
-			return e
-		}
-
-		//can be optimized by implement a decoder
-		bytes, e = json.Marshal(values)
-
-		if e != nil {
-			return e
-		}
-
-		e = json.Unmarshal(bytes, dst)
-		if e != nil {
-			return e
-		}
-
-		return nil
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
 
+	decoder := bodyDecoder(mediaType)
+	if decoder == nil {
+		return &ErrUnsupportedMediaType{MediaType: mediaType}
 	}
+	defer func() {
+		_ = c.Request.Body.Close()
+	}()
 
-	logger.Info("content-type: '%s' is not supported yet\n", ct)
-	return nil
+	if e := decoder.Decode(c, dst); e != nil {
+		return e
+	}
+	return Validate(dst)
 }
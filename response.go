@@ -0,0 +1,99 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import "net/http"
+
+// rich response returned by handlers, carrying status code, headers and cookies
+// the body is resolved by response resolvers as if it was returned directly
+//
+//	eg: return Created(user).Header("Location", "/users/1")
+type Response struct {
+	StatusCode int
+	Body       interface{}
+	Headers    http.Header
+	Cookies    []*http.Cookie
+}
+
+func Status(code int, body interface{}) *Response {
+	return &Response{StatusCode: code, Body: body, Headers: http.Header{}}
+}
+
+func OK(body interface{}) *Response {
+	return Status(http.StatusOK, body)
+}
+
+func Created(body interface{}) *Response {
+	return Status(http.StatusCreated, body)
+}
+
+func NoContent() *Response {
+	return Status(http.StatusNoContent, nil)
+}
+
+// code should be one of 301, 302, 303, 307 and 308
+func Redirect(url string, code int) *Response {
+	return Status(code, nil).Header("Location", url)
+}
+
+// headers are created if the Response is a literal, eg: &Response{StatusCode: 201}
+func (r *Response) Header(key string, value string) *Response {
+	if r.Headers == nil {
+		r.Headers = http.Header{}
+	}
+	r.Headers.Add(key, value)
+	return r
+}
+
+func (r *Response) Cookie(cookie *http.Cookie) *Response {
+	r.Cookies = append(r.Cookies, cookie)
+	return r
+}
+
+// apply the status, headers and cookies of a Response before anything is written
+// an explicit WriteHeader by the resolver (eg: error pages) overrides the status code
+type responseWriter struct {
+	http.ResponseWriter
+	response    *Response
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.ResponseWriter.Header()
+	for k, v := range w.response.Headers {
+		h[k] = v
+	}
+	for _, cookie := range w.response.Cookies {
+		http.SetCookie(w.ResponseWriter, cookie)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(w.status())
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// write the header if the body is empty
+func (w *responseWriter) finish() {
+	if !w.wroteHeader {
+		w.WriteHeader(w.status())
+	}
+}
+
+func (w *responseWriter) status() int {
+	if w.response.StatusCode == 0 {
+		return http.StatusOK
+	}
+	return w.response.StatusCode
+}
@@ -0,0 +1,30 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestResponseLiteral(t *testing.T) {
+	s := newTestServer()
+	s.POST("/headers", func(ctx *RequestCtx) interface{} {
+		return (&Response{StatusCode: http.StatusCreated}).Header("Location", "/users/1")
+	})
+	s.POST("/plain", func(ctx *RequestCtx) interface{} {
+		return &Response{StatusCode: http.StatusAccepted}
+	})
+
+	w := serve(s, "POST", "/headers", nil)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/users/1" {
+		t.Errorf("headers: %d %v", w.Code, w.Header())
+	}
+	if w := serve(s, "POST", "/plain", nil); w.Code != http.StatusAccepted {
+		t.Errorf("plain: %d", w.Code)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// admin endpoint listing mapped routes, disabled by default as it exposes the surface of the server
+//
+//	kinoko:
+//	  web:
+//	    admin:
+//	      routes:
+//	        enable: true
+//	        path: /admin/routes
+//	        auth: true
+//	        roles: admin, ops
+//
+// auth is the AuthProperty of the endpoint: true requires a principal, false skips authentication,
+// other values are the accepted schemes, comma separated, eg: basic, jwt
+// roles is the RolesProperty of the endpoint, comma separated, any principal is allowed if empty
+type AdminConfig struct {
+	EnableRoutes bool   `inject:"kinoko.web.admin.routes.enable:false"`
+	RoutesPath   string `inject:"kinoko.web.admin.routes.path:/admin/routes"`
+	RoutesAuth   string `inject:"kinoko.web.admin.routes.auth:true"`
+	RoutesRoles  string `inject:"kinoko.web.admin.routes.roles:"`
+}
+
+// description of a mapped route
+type RouteInfo struct {
+	// virtual host pattern, empty for the default routes
+	Host       string                 `json:"host,omitempty"`
+	Method     RequestMethod          `json:"method"`
+	Pattern    string                 `json:"pattern"`
+	Name       string                 `json:"name,omitempty"`
+	Handler    string                 `json:"handler"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+
+	// interceptors applying to the route, global ones first, then the ones of enclosing groups in order
+	Interceptors []string `json:"interceptors,omitempty"`
+}
+
+// mapped routes sorted by host, pattern and method
+func (s *HttpServer) Routes() []RouteInfo {
+	st := s.handlers.current()
+	routes := s.handlers.routes("", st.tables[nil])
+	for _, h := range st.hosts {
+		routes = append(routes, s.handlers.routes(h.pattern, st.tables[h])...)
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Host != routes[j].Host {
+			return routes[i].Host < routes[j].Host
+		}
+		if routes[i].Pattern != routes[j].Pattern {
+			return routes[i].Pattern < routes[j].Pattern
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+func (c *RequestHandler) routes(host string, table routeTable) []RouteInfo {
+	var routes []RouteInfo
+	for method, root := range table {
+		root.walk("", func(pattern string, node *prefixNode) {
+			info := RouteInfo{
+				Host:    host,
+				Method:  method,
+				Pattern: pattern,
+				Handler: node.handlerName,
+			}
+			// the properties are copied so the routes are not modified by callers
+			if len(node.properties) > 0 {
+				info.Properties = make(map[string]interface{}, len(node.properties))
+				for k, v := range node.properties {
+					info.Properties[k] = v
+				}
+			}
+			info.Name, _ = node.properties[RouteNameProperty].(string)
+			for _, entry := range node.selected {
+				info.Interceptors = append(info.Interceptors, entry.String())
+			}
+			routes = append(routes, info)
+		})
+	}
+	return routes
+}
+
+// visit mapped nodes in matching order
+func (n *prefixNode) walk(pattern string, visit func(pattern string, node *prefixNode)) {
+	if n.mapped {
+		if pattern == "" {
+			visit("/", n)
+		} else {
+			visit(pattern, n)
+		}
+	}
+	keys := make([]string, 0, len(n.children))
+	for k := range n.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		n.children[k].walk(pattern+"/"+k, visit)
+	}
+	for _, p := range n.params {
+		p.walk(pattern+"/"+p.prefix, visit)
+	}
+	if n.catchAll != nil {
+		n.catchAll.walk(pattern+"/"+n.catchAll.prefix, visit)
+	}
+}
+
+func funcName(fn interface{}) string {
+	v := reflect.ValueOf(fn)
+	if !v.IsValid() {
+		return ""
+	}
+	// eg: http.Handler implementations
+	if v.Kind() != reflect.Func {
+		return fmt.Sprintf("%T", fn)
+	}
+	if v.IsNil() {
+		return ""
+	}
+	if f := runtime.FuncForPC(v.Pointer()); f != nil {
+		return f.Name()
+	}
+	return ""
+}
+
+// route listed by the admin endpoint, properties are formatted as they may not be encodable
+type routeView struct {
+	Host         string            `json:"host,omitempty"`
+	Method       RequestMethod     `json:"method"`
+	Pattern      string            `json:"pattern"`
+	Name         string            `json:"name,omitempty"`
+	Handler      string            `json:"handler"`
+	Properties   map[string]string `json:"properties,omitempty"`
+	Interceptors []string          `json:"interceptors,omitempty"`
+}
+
+var routesPage = template.Must(template.New("routes").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Routes</title></head>
+<body>
+<table border="1" cellpadding="4" style="border-collapse:collapse">
+<tr><th>Host</th><th>Method</th><th>Pattern</th><th>Name</th><th>Handler</th><th>Properties</th><th>Interceptors</th></tr>
+{{range .}}<tr><td>{{.Host}}</td><td>{{.Method}}</td><td>{{.Pattern}}</td><td>{{.Name}}</td><td>{{.Handler}}</td><td>{{range $k, $v := .Properties}}{{$k}}={{$v}}<br>{{end}}</td><td>{{range .Interceptors}}{{.}}<br>{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// serve the routes as html if preferred by the client, otherwise negotiated as usual
+func (s *HttpServer) routesEndpoint(ctx *RequestCtx) interface{} {
+	var views []routeView
+	for _, r := range s.Routes() {
+		view := routeView{Host: r.Host, Method: r.Method, Pattern: r.Pattern, Name: r.Name, Handler: r.Handler, Interceptors: r.Interceptors}
+		if len(r.Properties) > 0 {
+			view.Properties = map[string]string{}
+			for k, v := range r.Properties {
+				view.Properties[k] = fmt.Sprint(v)
+			}
+		}
+		views = append(views, view)
+	}
+
+	ranges := parseAccept(ctx.Request.Header.Get("Accept"))
+	if len(ranges) > 0 && ranges[0].q > 0 && strings.EqualFold(ranges[0].mediaType, "text/html") {
+		ctx.ResponseWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if e := routesPage.Execute(ctx.ResponseWriter, views); e != nil {
+			logger.Error("IO Error occurs at response -", e.Error())
+		}
+		return nil
+	}
+	return OK(views).Header("Cache-Control", "no-store")
+}
+
+func (s *HttpServer) mapAdminEndpoints() {
+	if s.AdminConfig == nil || !s.AdminConfig.EnableRoutes {
+		return
+	}
+	var properties []HandlerProperties
+	if auth := authPropertyValue(s.AdminConfig.RoutesAuth); auth != nil {
+		properties = append(properties, NewProperty(AuthProperty, auth))
+	}
+	if roles := splitList(s.AdminConfig.RoutesRoles); len(roles) > 0 {
+		properties = append(properties, NewProperty(RolesProperty, roles))
+	}
+	s.GET(s.AdminConfig.RoutesPath, s.routesEndpoint, properties...)
+	logger.Warn("Routes are exposed at", s.AdminConfig.RoutesPath)
+}
+
+// "true", "false" or the schemes of a configured AuthProperty, nil if empty
+func authPropertyValue(config string) interface{} {
+	switch strings.ToLower(strings.TrimSpace(config)) {
+	case "":
+		return nil
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return splitList(config)
+}
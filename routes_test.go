@@ -0,0 +1,26 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"testing"
+)
+
+func TestRoutesPropertiesCopied(t *testing.T) {
+	s := newTestServer()
+	s.GET("/a", text("a"), NewProperty("x", 1))
+
+	s.Routes()[0].Properties["x"] = 2
+	s.Routes()[0].Properties["y"] = 3
+	node := matchRoute(s, Get, "/a", map[string]string{})
+	if node.properties["x"] != 1 || len(node.properties) != 1 {
+		t.Errorf("route properties modified: %v", node.properties)
+	}
+	if routes := s.Routes(); routes[0].Properties["x"] != 1 {
+		t.Errorf("Routes: %v", routes[0].Properties)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"path"
+	"strings"
+)
+
+// routes an interceptor applies to, the zero value applies to every route
+// patterns are Ant-style and matched against route patterns as they are mapped, eg: "/users/:id"
+//
+//	"/api/**"        the prefix and every route below it
+//	"/users/*"       a single segment
+//	"/files/*.json"  segments are matched by path.Match
+type InterceptorRoutes struct {
+	// routes matching any of the patterns, every route if empty
+	Include []string
+
+	// routes excluded even if included
+	Exclude []string
+
+	// routes of the methods, every method if empty
+	Methods []RequestMethod
+
+	// routes having every property, eg: RouteNameProperty
+	Properties []string
+}
+
+// optionally implemented by Interceptor and AroundInterceptor to apply to some routes only
+// the interceptors of a route are selected when it is mapped, not checked on each request
+//
+//	eg: func (a *Audit) Routes() InterceptorRoutes {
+//		return InterceptorRoutes{Include: []string{"/api/**"}, Exclude: []string{"/api/health"}, Methods: []RequestMethod{Post, Put, Delete}}
+//	}
+type SelectiveInterceptor interface {
+	Routes() InterceptorRoutes
+}
+
+// routes mapped by Any keep the method filters, they are checked on each request
+func (e interceptorEntry) applies(method RequestMethod, pattern string, properties map[string]interface{}) bool {
+	r := e.routes
+	if r == nil {
+		return true
+	}
+	if method != AnyMethod && e.methods != nil && !e.methods[method] {
+		return false
+	}
+	if len(r.Include) > 0 && !antMatchAny(r.Include, pattern) {
+		return false
+	}
+	if antMatchAny(r.Exclude, pattern) {
+		return false
+	}
+	for _, k := range r.Properties {
+		if _, ok := properties[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// interceptors applying to the route, global ones first, then the ones of enclosing groups
+func (c *RequestHandler) selectInterceptors(method RequestMethod, pattern string, node *prefixNode) []interceptorEntry {
+	var selected []interceptorEntry
+	chains := append([]*InterceptorChain{&c.interceptorChain}, node.interceptors...)
+	for _, chain := range chains {
+		for _, entry := range chain.snapshot() {
+			if !entry.applies(method, pattern, node.properties) {
+				continue
+			}
+			if method != AnyMethod {
+				entry.methods = nil
+			}
+			selected = append(selected, entry)
+		}
+	}
+	return selected
+}
+
+// select the interceptors of every route again once the chain changed, its constraints are validated
+func (s *HttpServer) reselectInterceptors(scope routeScope, chain *InterceptorChain) {
+	tx := scope.tx
+	if tx == nil {
+		tx = s.handlers.begin()
+		defer func() {
+			s.mappingErrors = append(s.mappingErrors, tx.errors...)
+			s.handlers.commit(tx)
+		}()
+	}
+	tx.fail(chain.validate())
+	tx.reselect(s.handlers)
+}
+
+func (tx *routeTx) reselect(c *RequestHandler) {
+	for host, table := range tx.state.tables {
+		for method := range table {
+			tx.ownAll(tx.root(host, method), "", func(pattern string, node *prefixNode) {
+				node.selected = c.selectInterceptors(method, pattern, node)
+			})
+		}
+	}
+}
+
+// visit mapped nodes of the trie, every node is copied to be modified
+func (tx *routeTx) ownAll(n *prefixNode, pattern string, visit func(pattern string, node *prefixNode)) {
+	if n.mapped {
+		if pattern == "" {
+			visit("/", n)
+		} else {
+			visit(pattern, n)
+		}
+	}
+	for k, child := range n.children {
+		child = tx.own(child)
+		n.children[k] = child
+		tx.ownAll(child, pattern+"/"+k, visit)
+	}
+	for i, p := range n.params {
+		p = tx.own(p)
+		n.params[i] = p
+		tx.ownAll(p, pattern+"/"+p.prefix, visit)
+	}
+	if n.catchAll != nil {
+		n.catchAll = tx.own(n.catchAll)
+		tx.ownAll(n.catchAll, pattern+"/"+n.catchAll.prefix, visit)
+	}
+}
+
+func antMatchAny(patterns []string, route string) bool {
+	for _, p := range patterns {
+		if antMatch(p, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// "**" matches any number of segments, invalid patterns match nothing
+//
+//	eg: antMatch("/api/**", "/api") => true
+func antMatch(pattern string, route string) bool {
+	return antMatchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(strings.Trim(route, "/"), "/"))
+}
+
+func antMatchSegments(pattern []string, route []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(route); i++ {
+				if antMatchSegments(pattern[1:], route[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(route) == 0 {
+			return false
+		}
+		if ok, e := path.Match(pattern[0], route[0]); e != nil || !ok {
+			return false
+		}
+		pattern, route = pattern[1:], route[1:]
+	}
+	return len(route) == 0
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"testing"
+)
+
+// blocks the selected routes with its name
+type selectiveInterceptor struct {
+	name   string
+	routes InterceptorRoutes
+}
+
+func (i *selectiveInterceptor) Priority() int {
+	return 0
+}
+
+func (i *selectiveInterceptor) Intercept(ctx *RequestCtx, properties map[string]interface{}) (InterceptorAction, interface{}) {
+	return Block, i.name
+}
+
+func (i *selectiveInterceptor) Routes() InterceptorRoutes {
+	return i.routes
+}
+
+func TestAntMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern, route string
+		expected       bool
+	}{
+		{"/api/**", "/api", true},
+		{"/api/**", "/api/users/:id", true},
+		{"/api/**", "/apis", false},
+		{"/users/*", "/users/:id", true},
+		{"/users/*", "/users/:id/posts", false},
+		{"/files/*.json", "/files/a.json", true},
+		{"/files/*.json", "/files/a.xml", false},
+		{"/**/health", "/health", true},
+		{"/**/health", "/v1/api/health", true},
+		{"/", "/", true},
+		{"/[", "/[", false},
+	} {
+		if antMatch(c.pattern, c.route) != c.expected {
+			t.Errorf("antMatch(%q, %q) != %v", c.pattern, c.route, c.expected)
+		}
+	}
+}
+
+func TestInterceptorSelection(t *testing.T) {
+	s := newTestServer()
+	s.GET("/api/users/:id", text("user"))
+	s.POST("/api/users", text("created"))
+	s.GET("/api/health", text("healthy"))
+	s.GET("/api/report", text("report"), NewProperty(RouteNameProperty, "report"))
+	s.Any("/api/any", text("any"))
+	s.GET("/home", text("home"))
+
+	// selected again for the routes already mapped
+	s.AddInterceptor(&selectiveInterceptor{"audit", InterceptorRoutes{
+		Include: []string{"/api/**"},
+		Exclude: []string{"/api/health", "/api/report"},
+		Methods: []RequestMethod{"post", Delete},
+	}})
+	s.AddInterceptor(&selectiveInterceptor{"named", InterceptorRoutes{Properties: []string{RouteNameProperty}}})
+	s.GET("/api/named", text("named"), NewProperty(RouteNameProperty, "named"))
+
+	for _, c := range []struct {
+		method, url, expected string
+	}{
+		{"GET", "/api/users/1", "user"},
+		{"POST", "/api/users", "audit"},
+		{"GET", "/api/health", "healthy"},
+		{"GET", "/api/report", "named"},
+		{"GET", "/api/named", "named"},
+		{"GET", "/home", "home"},
+		// method filters of routes mapped by Any are checked on each request
+		{"GET", "/api/any", "any"},
+		{"DELETE", "/api/any", "audit"},
+	} {
+		if w := serve(s, c.method, c.url, nil); w.Body.String() != c.expected {
+			t.Errorf("%s %s: %q, expected %q", c.method, c.url, w.Body.String(), c.expected)
+		}
+	}
+}
+
+func TestGroupInterceptorSelection(t *testing.T) {
+	s := newTestServer()
+	s.Group("/admin").GET("/users", text("users"))
+	s.Group("/admin").POST("/users", text("created"))
+	s.GET("/admin/settings", text("settings"))
+	s.GET("/settings", text("public"))
+
+	// a selective interceptor of a group guards the routes below the prefix mapped by any mapper
+	s.Group("/admin").AddInterceptor(&selectiveInterceptor{"admin", InterceptorRoutes{
+		Include: []string{"/admin/settings"},
+	}})
+	s.Group("/admin").AddAroundInterceptor(replacingAround{})
+
+	for _, c := range []struct {
+		method, url, expected string
+	}{
+		{"GET", "/admin/users", "USERS"},
+		{"POST", "/admin/users", "CREATED"},
+		// the blocking interceptor was added first, so it runs outside the around one
+		{"GET", "/admin/settings", "admin"},
+		{"GET", "/settings", "public"},
+	} {
+		if w := serve(s, c.method, c.url, nil); w.Body.String() != c.expected {
+			t.Errorf("%s %s: %q, expected %q", c.method, c.url, w.Body.String(), c.expected)
+		}
+	}
+}
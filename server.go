@@ -9,16 +9,17 @@ package kinoko_web
 import (
 	"container/list"
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/kinoko-projects/kinoko"
 	"html/template"
 	"net/http"
 	"os"
 	"os/signal"
-	"regexp"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -40,9 +41,21 @@ type SSLConfig struct {
 }
 
 type HttpServer struct {
-	handlers   *RequestHandler
-	HttpConfig *HttpConfig `inject:""`
-	SSLConfig  *SSLConfig  `inject:""`
+	handlers     *RequestHandler
+	HttpConfig   *HttpConfig   `inject:""`
+	SSLConfig    *SSLConfig    `inject:""`
+	CORSConfig   *CORSConfig   `inject:""`
+	ErrorConfig  *ErrorConfig  `inject:""`
+	AdminConfig  *AdminConfig  `inject:""`
+	RouterConfig *RouterConfig `inject:""`
+	ViewConfig   *ViewConfig   `inject:""`
+	AuthConfig   *AuthConfig   `inject:""`
+
+	// conflicting routes, fail the initialization
+	mappingErrors []error
+
+	// standard middlewares wrapping the handler
+	middlewares []Middleware
 }
 
 type RequestMapper interface {
@@ -50,7 +63,40 @@ type RequestMapper interface {
 	PUT(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper
 	DELETE(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper
 	POST(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper
+	PATCH(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper
+	HEAD(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper
+	OPTIONS(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper
+
+	// map the handler for every method, including custom ones, routes of the exact method take precedence
+	Any(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper
+
+	// map a typed handler, parameters are bound from the request, see bindHandler
+	//	eg: Bind(Get, "/users/:id", func(id int64, q SearchQuery) (*User, error) {...})
+	Bind(method RequestMethod, pattern string, fn interface{}, properties ...HandlerProperties) RequestMapper
+
+	// any method token can be mapped, eg: RequestMethod("PROPFIND") for WebDAV
 	Mapping(method RequestMethod, pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper
+
+	// map a standard handler, the response is written by the handler
+	Handle(method RequestMethod, pattern string, h http.Handler, properties ...HandlerProperties) RequestMapper
+
+	// serve every method of the prefix and the paths below by a standard handler, the prefix is stripped
+	//	eg: Mount("/static", http.FileServer(http.Dir("public")))
+	Mount(prefix string, h http.Handler, properties ...HandlerProperties) RequestMapper
+
+	// serve files of the directory below the prefix, see StaticOptions
+	Static(prefix string, dir string, opts StaticOptions) RequestMapper
+
+	// remove a route mapped with the same method and pattern, optional segments included
+	// requests already matched are still served by the removed route
+	Unmap(method RequestMethod, pattern string) error
+
+	// sub-mapper sharing the prefix and properties, groups can be nested
+	Group(prefix string, properties ...HandlerProperties) RequestMapper
+
+	// interceptor only applied to the routes mapped by this mapper
+	AddInterceptor(interceptor Interceptor)
+	AddAroundInterceptor(interceptor AroundInterceptor)
 }
 
 type HttpController interface {
@@ -68,9 +114,29 @@ type RequestResolver interface {
 }
 
 type RequestHandler struct {
-	mapping          map[RequestMethod]*prefixNode
+	// published *routeState, replaced as a whole by mapping changes
+	published atomic.Value
+	// serializes mapping changes
+	mappingLock sync.Mutex
+
 	interceptorChain InterceptorChain
 	responseResolver *list.List
+
+	// interceptors of the groups by host and prefix, guarded by chainsLock
+	// groups are created while mapping changes are in progress, so mappingLock can not guard them
+	groupChains map[groupKey]*InterceptorChain
+	chainsLock  sync.Mutex
+
+	// global cors policy, nil if disabled
+	cors *CORSPolicy
+
+	exposeStacktrace bool
+	panicHandlers    []PanicHandler
+
+	// path policy, nil uses the default one
+	router *RouterConfig
+
+	views *viewEngine
 }
 
 type RequestMethod string
@@ -82,8 +148,12 @@ const (
 	Post    RequestMethod = "POST"
 	Put     RequestMethod = "PUT"
 	Delete  RequestMethod = "DELETE"
+	Patch   RequestMethod = "PATCH"
 	Head    RequestMethod = "HEAD"
 	Options RequestMethod = "OPTIONS"
+
+	// matches every method, see RequestMapper.Any
+	AnyMethod RequestMethod = "*"
 )
 
 type RequestMapping struct {
@@ -100,8 +170,23 @@ type prefixNode struct {
 	placeholder string
 	handler     RequestHandlerFunc
 	properties  map[string]interface{}
-	parent      *prefixNode
-	children    map[string]*prefixNode
+	// interceptors of enclosing groups, outermost first
+	interceptors []*InterceptorChain
+	// interceptors applying to the route, selected again once a chain changes
+	selected []interceptorEntry
+	children map[string]*prefixNode
+
+	// path constraint of placeholder, nil matches any
+	constraint string
+	matcher    func(string) bool
+
+	// name of the mapped function, reported by Routes
+	handlerName string
+
+	// placeholder children, constrained ones first
+	params []*prefixNode
+	// catch-all placeholder child, matchAll is set
+	catchAll *prefixNode
 }
 
 type HandlerProperties struct {
@@ -109,14 +194,6 @@ type HandlerProperties struct {
 	v interface{}
 }
 
-var urlFormatRegexp []*regexp.Regexp
-
-func init() {
-	r1, _ := regexp.Compile("\\.+/")
-	r2, _ := regexp.Compile("/{2,}")
-	urlFormatRegexp = []*regexp.Regexp{r1, r2}
-}
-
 func (s *HttpServer) GET(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
 	return s.Mapping(Get, pattern, handler, properties...)
 }
@@ -129,79 +206,143 @@ func (s *HttpServer) DELETE(pattern string, handler RequestHandlerFunc, properti
 func (s *HttpServer) PUT(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
 	return s.Mapping(Put, pattern, handler, properties...)
 }
+func (s *HttpServer) PATCH(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
+	return s.Mapping(Patch, pattern, handler, properties...)
+}
+func (s *HttpServer) HEAD(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
+	return s.Mapping(Head, pattern, handler, properties...)
+}
+func (s *HttpServer) OPTIONS(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
+	return s.Mapping(Options, pattern, handler, properties...)
+}
+func (s *HttpServer) Any(pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
+	return s.Mapping(AnyMethod, pattern, handler, properties...)
+}
 
 func (s *HttpServer) Mapping(method RequestMethod, pattern string, handler RequestHandlerFunc, properties ...HandlerProperties) RequestMapper {
+	s.mapRoute(routeScope{}, method, pattern, handler, handler, properties)
+	return s
+}
+
+func (s *HttpServer) Bind(method RequestMethod, pattern string, fn interface{}, properties ...HandlerProperties) RequestMapper {
+	s.mapRoute(routeScope{}, method, pattern, bindHandler(pattern, fn), fn, properties)
+	return s
+}
 
-	if s.handlers.mapping == nil {
-		s.handlers.mapping = map[RequestMethod]*prefixNode{
-			Get:     {prefix: "", children: map[string]*prefixNode{}},
-			Put:     {prefix: "", children: map[string]*prefixNode{}},
-			Post:    {prefix: "", children: map[string]*prefixNode{}},
-			Delete:  {prefix: "", children: map[string]*prefixNode{}},
-			Head:    {prefix: "", children: map[string]*prefixNode{}},
-			Options: {prefix: "", children: map[string]*prefixNode{}}}
+func (s *HttpServer) Group(prefix string, properties ...HandlerProperties) RequestMapper {
+	return newRouteGroup(s, nil, prefix, properties)
+}
 
+// source is the function reported as the handler of the route, eg: the bound function
+func (s *HttpServer) mapRoute(scope routeScope, method RequestMethod, pattern string, handler RequestHandlerFunc, source interface{}, properties []HandlerProperties) {
+	method = RequestMethod(strings.TrimSpace(string(method)))
+	if method == "" {
+		panic("empty method")
 	}
 	// format pattern
 	pattern = strings.TrimSpace(pattern)
 	if len(pattern) == 0 {
 		panic("empty pattern")
 	}
-	if pattern[0] == '/' {
-		if len(pattern) == 1 {
-			pattern = ""
-		} else {
-			pattern = pattern[1:]
+
+	tx := scope.tx
+	if tx == nil {
+		tx = s.handlers.begin()
+		defer func() {
+			s.mappingErrors = append(s.mappingErrors, tx.errors...)
+			s.handlers.commit(tx)
+		}()
+	}
+	if name, ok := propertyValue(properties, RouteNameProperty).(string); ok && name != "" {
+		tx.fail(tx.nameRoute(scope.host, name, pattern))
+	}
+	if e := checkAccessProperties(properties); e != nil {
+		tx.fail(fmt.Errorf("%s %s: %v", method, pattern, e))
+	}
+	// optional segments are mapped as separated routes
+	for _, p := range expandOptional(pattern) {
+		node, e := tx.insert(scope.host, method, p, handler, properties)
+		if e != nil {
+			tx.fail(e)
+			continue
 		}
+		node.handlerName = funcName(source)
+		node.interceptors = s.handlers.enclosingChains(scope.host, p)
+		node.selected = s.handlers.selectInterceptors(method, "/"+p, node)
 	}
+}
+
+// insert the route into the trie, conflicting routes are rejected
+// nodes of the path are copied, published tries are never modified
+func (tx *routeTx) insert(host *virtualHost, method RequestMethod, pattern string, handler RequestHandlerFunc, properties []HandlerProperties) (*prefixNode, error) {
 	prefixes := strings.Split(pattern, "/")
-	currentNode := s.handlers.mapping[method]
+	currentNode := tx.root(host, method)
 	for i := 0; pattern != "" && i < len(prefixes); i++ {
-		prefix := prefixes[i]
-		placeholder := ""
+		seg := parseSegment(prefixes[i])
 
 		//node already match all patterns
 		if currentNode.matchAll {
-			panic("ambiguous mapping")
+			return nil, fmt.Errorf("%s /%s: catch-all placeholder must be the last segment", method, pattern)
 		}
 
-		if len(prefix) > 0 && prefix[0] == ':' {
-			placeholder = prefix[1:]
-			prefix = "*"
-		} else if len(prefix) > 0 && prefix[0] == '*' {
-			placeholder = prefix[1:]
-			prefix = "*"
-			if i < len(prefix)-1 {
-				logger.Error(pattern, "full pattern placeholder must be the end")
-				return s
+		var nextNode *prefixNode
+		switch seg.kind {
+		case catchAllSegment:
+			if currentNode.catchAll != nil && currentNode.catchAll.prefix != seg.String() {
+				return nil, fmt.Errorf("%s /%s: conflicts with catch-all placeholder %s", method, pattern, currentNode.catchAll.prefix)
 			}
-
-			if currentNode.children[prefix] != nil {
-				logger.Error(pattern, "ambiguous mapping")
-				return s
+			nextNode = tx.own(currentNode.catchAll)
+			if nextNode == nil {
+				node, e := newPlaceholderNode(seg)
+				if e != nil {
+					return nil, fmt.Errorf("%s /%s: %v", method, pattern, e)
+				}
+				nextNode = tx.fresh(node)
+				nextNode.matchAll = true
 			}
+			currentNode.catchAll = nextNode
 
-			nextNode := &prefixNode{prefix: prefix, placeholder: placeholder,
-				mapped: false, children: nil, matchAll: true}
-			currentNode.children[prefix] = nextNode
-			currentNode = nextNode
-			break
-
-		}
-
-		nextNode := currentNode.children[prefix]
+		case paramSegment:
+			for n, p := range currentNode.params {
+				if p.constraint == seg.constraint {
+					nextNode = tx.own(p)
+					currentNode.params[n] = nextNode
+				}
+			}
+			if nextNode != nil && nextNode.placeholder != seg.value {
+				return nil, fmt.Errorf("%s /%s: conflicts with placeholder %s", method, pattern, nextNode.prefix)
+			}
+			//allocate placeholder node, constrained ones are matched first
+			if nextNode == nil {
+				node, e := newPlaceholderNode(seg)
+				if e != nil {
+					return nil, fmt.Errorf("%s /%s: %v", method, pattern, e)
+				}
+				nextNode = tx.fresh(node)
+				if seg.constraint == "" {
+					currentNode.params = append(currentNode.params, nextNode)
+				} else {
+					n := 0
+					for n < len(currentNode.params) && currentNode.params[n].constraint != "" {
+						n++
+					}
+					currentNode.params = append(currentNode.params[:n], append([]*prefixNode{nextNode}, currentNode.params[n:]...)...)
+				}
+			}
 
-		//allocate children node
-		if nextNode == nil {
-			nextNode = &prefixNode{prefix: prefix, placeholder: placeholder,
-				mapped: false, children: map[string]*prefixNode{}, matchAll: false}
-			currentNode.children[prefix] = nextNode
+		default:
+			nextNode = tx.own(currentNode.children[seg.value])
+			//allocate children node
+			if nextNode == nil {
+				nextNode = tx.fresh(&prefixNode{prefix: seg.value, children: map[string]*prefixNode{}})
+			}
+			currentNode.children[seg.value] = nextNode
 		}
 		currentNode = nextNode
 	}
 
 	if currentNode.mapped {
-		logger.Error(pattern, "ambiguous mapping")
+		return nil, fmt.Errorf("%s /%s: route is already mapped", method, pattern)
 	}
 
 	currentNode.mapped = true
@@ -213,94 +354,265 @@ func (s *HttpServer) Mapping(method RequestMethod, pattern string, handler Reque
 	}
 
 	logger.Info("URL Mapped", method, "/"+pattern)
-	return s
+	return currentNode, nil
 }
 
-func (c *RequestHandler) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
-	var obj interface{} = nil
-	pv := make(map[string]string)
-	url := r.URL.Path
+func newPlaceholderNode(seg segment) (*prefixNode, error) {
+	matcher, e := compileConstraint(seg.constraint)
+	if e != nil {
+		return nil, e
+	}
+	return &prefixNode{prefix: seg.String(), placeholder: seg.value, constraint: seg.constraint,
+		matcher: matcher, children: map[string]*prefixNode{}}, nil
+}
 
-	//format the url
-	for _, reg := range urlFormatRegexp {
-		url = reg.ReplaceAllString(url, "/")
+// find the mapped node of the method, returns nil if unmapped
+func (t routeTable) match(method RequestMethod, url string, pv map[string]string, fold bool) *prefixNode {
+	root := t[method]
+	if root == nil {
+		return nil
 	}
+	path := url[1:]
+	return root.lookup(path, path == "", pv, fold)
+}
 
-	split := strings.Split(url[1:], "/")
-	currentNode := c.mapping[RequestMethod(r.Method)]
+// backtracking lookup, static children are preferred to placeholders and placeholders to catch-all
+// path is the rest of the escaped url, end tells if no segment is left, fold matches static segments ignoring case
+// path variables are unescaped and only set on the matched branch
+func (n *prefixNode) lookup(path string, end bool, pv map[string]string, fold bool) *prefixNode {
+	if end {
+		if n.mapped {
+			return n
+		}
+		return nil
+	}
 
-	for i := 0; url[1:] != "" && i < len(split); i++ {
-		s := split[i]
-		node := currentNode.children[s]
+	seg, rest, last := path, "", true
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		seg, rest, last = path[:i], path[i+1:], false
+	}
+	seg = unescapePath(seg)
 
-		if node == nil {
-			node = currentNode.children["*"]
-			if node == nil {
-				currentNode = nil
-				break
+	if child := n.children[seg]; child != nil {
+		if found := child.lookup(rest, last, pv, fold); found != nil {
+			return found
+		}
+	}
+	if fold {
+		for prefix, child := range n.children {
+			if prefix == seg || !strings.EqualFold(prefix, seg) {
+				continue
 			}
-			if node.matchAll {
-				pv[node.placeholder] = strings.Join(split[i:], "/")
-				currentNode = node
-				break
-			} else {
-				pv[node.placeholder] = s
+			if found := child.lookup(rest, last, pv, fold); found != nil {
+				return found
 			}
 		}
-		currentNode = node
 	}
 
-	//mapped
-	if currentNode != nil && currentNode.mapped {
+	if seg != "" {
+		for _, p := range n.params {
+			if p.matcher != nil && !p.matcher(seg) {
+				continue
+			}
+			if found := p.lookup(rest, last, pv, fold); found != nil {
+				pv[p.placeholder] = seg
+				return found
+			}
+		}
+	}
+
+	if all := n.catchAll; all != nil && all.mapped {
+		remain := unescapePath(path)
+		if all.matcher == nil || all.matcher(remain) {
+			pv[all.placeholder] = remain
+			return all
+		}
+	}
+	return nil
+}
 
-		ctx := NewRequestCtx(r.URL.Query(), pv, r, r.MultipartForm, wr)
+// find the node serving the method, HEAD falls back to GET and any method falls back to AnyMethod
+// head tells if the node is a GET handler serving a HEAD request
+func (t routeTable) lookup(method RequestMethod, url string, pv map[string]string, fold bool) (node *prefixNode, head bool) {
+	if node = t.match(method, url, pv, fold); node != nil {
+		return node, false
+	}
+	if method == Head {
+		if node = t.match(Get, url, clearMap(pv), fold); node != nil {
+			return node, true
+		}
+	}
+	return t.match(AnyMethod, url, clearMap(pv), fold), false
+}
 
-		//recover from any exception
-		defer func() {
-			//panic
-			if err := recover(); err != nil {
-				if ctx.SQL != nil {
-					ctx.SQL.Rollback() //rollback any uncommitted transaction
-				}
-				HttpError(wr, http.StatusInternalServerError, fmt.Sprint(err), true)
-			}
-		}()
+func clearMap(m map[string]string) map[string]string {
+	for k := range m {
+		delete(m, k)
+	}
+	return m
+}
 
-		var intercepted bool
-		// firstly, handle with interceptorChain
-		intercepted, obj = c.interceptorChain.CallInterceptors(ctx, currentNode.properties)
+// methods mapped for the url, used by the Allow header
+func (t routeTable) allowedMethods(url string, fold bool) []string {
+	var allowed []string
+	for method := range t {
+		if method != AnyMethod && t.match(method, url, map[string]string{}, fold) != nil {
+			allowed = append(allowed, string(method))
+		}
+	}
+	// HEAD requests are served by GET routes unless the path has its own HEAD route
+	if t.match(Head, url, map[string]string{}, fold) == nil && t.match(Get, url, map[string]string{}, fold) != nil {
+		allowed = append(allowed, string(Head))
+	}
+	sort.Strings(allowed)
+	return allowed
+}
 
-		if !intercepted {
-			obj = currentNode.handler(ctx)
+func (c *RequestHandler) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
+	pv := make(map[string]string)
+	method := RequestMethod(r.Method)
+
+	// routes of the virtual host, host placeholders are added to path variables
+	hostVariables := map[string]string{}
+	st := c.current()
+	host := st.matchHost(r.Host, hostVariables)
+	table := st.tables[host]
+
+	// the escaped path is matched so escaped slashes are kept in path variables
+	url := r.URL.EscapedPath()
+	if url == "" {
+		url = "/"
+	}
+	policy := c.routerConfig()
+	fold := policy.CaseInsensitive
+	if !policy.Strict {
+		if clean := cleanPath(url); clean != url {
+			if policy.RedirectCleanPath {
+				redirectPath(wr, r, clean)
+				return
+			}
+			url = clean
 		}
+	}
+
+	//cors preflight is answered automatically unless OPTIONS is mapped explicitly
+	if method == Options && isPreflight(r) && table.match(Options, url, map[string]string{}, fold) == nil {
+		if c.preflight(wr, r, table, url, fold) {
+			return
+		}
+	}
 
-		//find a proper response wrapper
-		for e := c.responseResolver.Front(); e != nil; e = e.Next() {
-			if e.Value.(ResponseResolver).ResolveResponse(obj, wr) {
+	currentNode, head := table.lookup(method, url, pv, fold)
+	for k, v := range hostVariables {
+		if _, ok := pv[k]; !ok {
+			pv[k] = v
+		}
+	}
+	if currentNode == nil && !policy.Strict && policy.RedirectTrailingSlash {
+		if toggled := toggleTrailingSlash(url); toggled != "" {
+			if node, _ := table.lookup(method, toggled, map[string]string{}, fold); node != nil {
+				redirectPath(wr, r, toggled)
 				return
 			}
 		}
-		//commit any uncommitted transaction
-		if ctx.SQL != nil {
-			ctx.SQL.Commit()
+	}
+	if head {
+		wr = &headResponseWriter{wr}
+	}
+	if currentNode != nil {
+		c.corsHeaders(wr, r, currentNode)
+	}
+
+	//mapped
+	if currentNode != nil && currentNode.mapped {
+		// route middlewares wrap the interceptors and the handler
+		if middlewares := routeMiddlewares(currentNode.properties); len(middlewares) > 0 {
+			chainMiddlewares(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+				c.serve(wr, r, host, currentNode, pv)
+			}), middlewares).ServeHTTP(wr, r)
+		} else {
+			c.serve(wr, r, host, currentNode, pv)
 		}
-		//default wrapper
-		c.defaultResponseResolver(obj, wr)
+	} else if allowed := table.allowedMethods(url, fold); len(allowed) > 0 {
+		//mapped with other methods
+		wr.Header().Set("Allow", strings.Join(allowed, ", "))
+		renderError(wr, r, NewHTTPError(http.StatusMethodNotAllowed, "method %s is not allowed", r.Method))
 	} else {
 		//unmapped url
-		http.NotFound(wr, r)
+		renderError(wr, r, NotFound("page not found"))
 		return
 	}
 
 }
 
+// serve the request by the mapped node
+func (c *RequestHandler) serve(wr http.ResponseWriter, r *http.Request, host *virtualHost, currentNode *prefixNode, pv map[string]string) {
+	var obj interface{} = nil
+	ctx := NewRequestCtx(r.URL.Query(), pv, r, r.MultipartForm, wr)
+	ctx.handlers = c
+	ctx.host = host
+	ctx.properties = currentNode.properties
+
+	//recover from any exception
+	defer func() {
+		//panic
+		if err := recover(); err != nil {
+			if ctx.SQL != nil {
+				ctx.SQL.Rollback() //rollback any uncommitted transaction
+			}
+			c.recoverPanic(ctx, wr, err)
+		}
+		if ctx.SQL != nil {
+			ctx.SQL.close()
+		}
+	}()
+
+	// the handler is wrapped by the interceptors, global ones first
+	obj = invokeInterceptors(currentNode.selected, ctx, currentNode.properties, func() interface{} {
+		return currentNode.handler(ctx)
+	})
+
+	//status, headers and cookies are applied when the body is written
+	if response, ok := obj.(*Response); ok {
+		rw := &responseWriter{ResponseWriter: wr, response: response}
+		c.resolveResponse(ctx, response.Body, rw)
+		rw.finish()
+	} else {
+		c.resolveResponse(ctx, obj, wr)
+	}
+}
+
+// response writer of HEAD requests served by GET handlers, headers are kept and the body is discarded
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w *headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (c *RequestHandler) resolveResponse(ctx *RequestCtx, obj interface{}, wr http.ResponseWriter) {
+	//find a proper response wrapper
+	for e := c.responseResolver.Front(); e != nil; e = e.Next() {
+		if e.Value.(ResponseResolver).ResolveResponse(obj, wr) {
+			return
+		}
+	}
+	//commit any uncommitted transaction
+	if ctx.SQL != nil {
+		ctx.SQL.Commit()
+	}
+	//default wrapper
+	c.defaultResponseResolver(ctx, obj, wr)
+}
+
 // append to the top of response
 func (s *HttpServer) AddResponseResolver(wrapper ResponseResolver) {
 	s.handlers.responseResolver.PushFront(wrapper)
 }
 
 //default response wrapper
-func (c *RequestHandler) defaultResponseResolver(v interface{}, wr http.ResponseWriter) bool {
+func (c *RequestHandler) defaultResponseResolver(ctx *RequestCtx, v interface{}, wr http.ResponseWriter) bool {
 	var err error
 
 	//Nil
@@ -308,9 +620,22 @@ func (c *RequestHandler) defaultResponseResolver(v interface{}, wr http.Response
 		return true
 	}
 
-	//Unhandled error
+	//HTTPError, unhandled errors are responded as 500
 	if e, ok := v.(error); ok {
-		HttpError(wr, http.StatusInternalServerError, e.Error(), false)
+		renderError(wr, ctx.Request, toHTTPError(e))
+		return true
+	}
+
+	//html view
+	if view, ok := v.(*ViewResponse); ok {
+		if c.views == nil {
+			renderError(wr, ctx.Request, InternalServerError("views are not configured"))
+			return true
+		}
+		if e := c.views.render(wr, view); e != nil {
+			logger.Error("Failed to render view", view.Name, "-", e.Error())
+			renderError(wr, ctx.Request, InternalServerError("failed to render view %s", view.Name))
+		}
 		return true
 	}
 
@@ -319,12 +644,7 @@ func (c *RequestHandler) defaultResponseResolver(v interface{}, wr http.Response
 		wr.Header().Set("Content-Type", "text/plain")
 		_, err = wr.Write([]byte((v).(string))) //return origin value if string
 	default:
-		wr.Header().Set("Content-Type", "application/json")
-		if bytes, err := json.Marshal(v); err != nil {
-			http.Error(wr, err.Error(), 500)
-		} else {
-			_, err = wr.Write(bytes)
-		}
+		err = writeNegotiated(ctx, wr, v) //negotiated by Accept header
 	}
 
 	if err != nil {
@@ -343,6 +663,51 @@ func (s *HttpServer) StartServerAsync() *http.Server {
 
 func (s *HttpServer) AddInterceptor(interceptor Interceptor) {
 	s.handlers.interceptorChain.AddInterceptor(interceptor)
+	s.reselectInterceptors(routeScope{}, &s.handlers.interceptorChain)
+}
+
+func (s *HttpServer) AddAroundInterceptor(interceptor AroundInterceptor) {
+	s.handlers.interceptorChain.AddAroundInterceptor(interceptor)
+	s.reselectInterceptors(routeScope{}, &s.handlers.interceptorChain)
+}
+
+// groups of the same prefix on the same host share their interceptors, nil host for the default routes
+type groupKey struct {
+	host   *virtualHost
+	prefix string
+}
+
+// chain of the group prefix, groups of the same prefix share it
+func (c *RequestHandler) groupChain(host *virtualHost, prefix string) *InterceptorChain {
+	c.chainsLock.Lock()
+	defer c.chainsLock.Unlock()
+	if c.groupChains == nil {
+		c.groupChains = map[groupKey]*InterceptorChain{}
+	}
+	key := groupKey{host: host, prefix: prefix}
+	chain := c.groupChains[key]
+	if chain == nil {
+		chain = NewInterceptorChain()
+		c.groupChains[key] = chain
+	}
+	return chain
+}
+
+// chains of the prefixes enclosing the pattern, outermost first
+// a chain is created for each prefix so the groups created after mapping the route guard it too
+//
+//	eg: enclosingChains(nil, "admin/users") => chains of "/", "/admin" and "/admin/users"
+func (c *RequestHandler) enclosingChains(host *virtualHost, pattern string) []*InterceptorChain {
+	chains := []*InterceptorChain{c.groupChain(host, "/")}
+	prefix := ""
+	for _, seg := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		if seg == "" {
+			continue
+		}
+		prefix += "/" + seg
+		chains = append(chains, c.groupChain(host, prefix))
+	}
+	return chains
 }
 func (s *HttpServer) startWith(block bool) *http.Server {
 
@@ -352,7 +717,7 @@ func (s *HttpServer) startWith(block bool) *http.Server {
 	}
 
 	server := &http.Server{
-		Handler:           s.handlers,
+		Handler:           s.Handler(),
 		Addr:              s.HttpConfig.Address,
 		WriteTimeout:      s.HttpConfig.WriteTimeout,
 		ReadTimeout:       s.HttpConfig.ReadTimeout,
@@ -378,12 +743,11 @@ func (s *HttpServer) startWith(block bool) *http.Server {
 		return server
 	}
 
-	sig := make(chan os.Signal)
+	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT)
 	<-sig
 
-	c, _ := context.WithCancel(context.Background())
-	_ = server.Shutdown(c)
+	_ = server.Shutdown(context.Background())
 	return nil
 }
 
@@ -0,0 +1,212 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// server serving mapped routes without kinoko initialization
+func newTestServer() *HttpServer {
+	sqlPropertiesHolder.SQL = &SQL{}
+	return &HttpServer{handlers: &RequestHandler{responseResolver: list.New()}}
+}
+
+func serve(s *HttpServer, method string, url string, header http.Header) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, url, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	s.handlers.ServeHTTP(w, r)
+	return w
+}
+
+// the mapped node of the default routes, nil if unmapped
+func matchRoute(s *HttpServer, method RequestMethod, url string, pv map[string]string) *prefixNode {
+	return s.handlers.current().tables[nil].match(method, url, pv, false)
+}
+
+func text(v string) RequestHandlerFunc {
+	return func(ctx *RequestCtx) interface{} {
+		return v
+	}
+}
+
+func TestAllowHeadServedByGet(t *testing.T) {
+	s := newTestServer()
+	s.GET("/a", text("a"))
+	s.HEAD("/b", text("b"))
+
+	w := serve(s, "PUT", "/a", nil)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("PUT /a: %d Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+	if w := serve(s, "HEAD", "/a", nil); w.Code != http.StatusOK {
+		t.Errorf("HEAD /a: %d", w.Code)
+	}
+	if w := serve(s, "PUT", "/b", nil); w.Header().Get("Allow") != "HEAD" {
+		t.Errorf("PUT /b: Allow %q", w.Header().Get("Allow"))
+	}
+}
+
+func TestBacktracking(t *testing.T) {
+	s := newTestServer()
+	s.GET("/a/b/c", variables("static"))
+	s.GET("/:x/b/d", variables("param"))
+	s.GET("/:x/b/:y<int>", variables("constrained"))
+	s.GET("/*rest", variables("catch-all"))
+
+	for url, expected := range map[string]string{
+		"/a/b/c": "static map[]",
+		"/a/b/d": "param map[x:a]",
+		"/z/b/d": "param map[x:z]",
+		"/a/b/1": "constrained map[x:a y:1]",
+		"/a/b/e": "catch-all map[rest:a/b/e]",
+		"/a":     "catch-all map[rest:a]",
+	} {
+		if w := serve(s, "GET", url, nil); w.Body.String() != expected {
+			t.Errorf("%s: %d %q, expected %q", url, w.Code, w.Body.String(), expected)
+		}
+	}
+}
+
+// the name of the route followed by the path variables
+func variables(name string) RequestHandlerFunc {
+	return func(ctx *RequestCtx) interface{} {
+		return fmt.Sprint(name, " ", ctx.PathVariable)
+	}
+}
+
+func TestMappingConflicts(t *testing.T) {
+	for _, c := range []struct {
+		patterns []string
+		err      string
+	}{
+		{[]string{"/a", "/a"}, "GET /a: route is already mapped"},
+		{[]string{"/f/*path/x"}, "GET /f/*path/x: catch-all placeholder must be the last segment"},
+		{[]string{"/f/*path", "/f/*file"}, "GET /f/*file: conflicts with catch-all placeholder *path"},
+		{[]string{"/u/:id", "/u/:name"}, "GET /u/:name: conflicts with placeholder :id"},
+		{[]string{"/c/:id<[>"}, "GET /c/:id<[>: invalid path constraint <[>: error parsing regexp: missing closing ]: `[)$`"},
+	} {
+		s := newTestServer()
+		for i, p := range c.patterns {
+			s.GET(p, text(strconv.Itoa(i)))
+		}
+		if len(s.mappingErrors) != 1 || s.mappingErrors[0].Error() != c.err {
+			t.Errorf("%v: %v, expected %s", c.patterns, s.mappingErrors, c.err)
+		}
+	}
+
+	// the conflicting route does not replace the mapped one
+	s := newTestServer()
+	s.GET("/a", text("first"))
+	s.GET("/a", text("second"))
+	if w := serve(s, "GET", "/a", nil); w.Body.String() != "first" {
+		t.Errorf("GET /a: %q", w.Body.String())
+	}
+}
+
+// static routes of a typical api
+func benchmarkRoutes() []string {
+	var routes []string
+	for _, resource := range []string{"users", "posts", "comments", "tags", "files"} {
+		for _, action := range []string{"list", "search", "export", "stats", "recent", "archived", "count", "import", "schema", "defaults"} {
+			routes = append(routes, "/api/v1/"+resource+"/"+action)
+		}
+	}
+	return append(routes, "/")
+}
+
+func BenchmarkStaticLookup(b *testing.B) {
+	s := newTestServer()
+	routes := benchmarkRoutes()
+	for _, r := range routes {
+		s.GET(r, text(r))
+	}
+	pv := map[string]string{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if matchRoute(s, Get, routes[i%len(routes)], pv) == nil {
+			b.Fatal("not matched")
+		}
+	}
+}
+
+// the greedy matcher replaced by the backtracking one, kept as the baseline of BenchmarkStaticLookup
+type greedyNode struct {
+	mapped      bool
+	matchAll    bool
+	placeholder string
+	children    map[string]*greedyNode
+}
+
+func (n *greedyNode) insert(pattern string) {
+	for i, prefix := range strings.Split(pattern[1:], "/") {
+		if i == 0 && prefix == "" {
+			break
+		}
+		next := n.children[prefix]
+		if len(prefix) > 0 && (prefix[0] == ':' || prefix[0] == '*') {
+			next = n.children["*"]
+			if next == nil {
+				next = &greedyNode{placeholder: prefix[1:], matchAll: prefix[0] == '*', children: map[string]*greedyNode{}}
+				n.children["*"] = next
+			}
+		} else if next == nil {
+			next = &greedyNode{children: map[string]*greedyNode{}}
+			n.children[prefix] = next
+		}
+		n = next
+	}
+	n.mapped = true
+}
+
+func (n *greedyNode) match(url string, pv map[string]string) *greedyNode {
+	split := strings.Split(url[1:], "/")
+	for i := 0; url[1:] != "" && i < len(split); i++ {
+		node := n.children[split[i]]
+		if node == nil {
+			if node = n.children["*"]; node == nil {
+				return nil
+			}
+			if node.matchAll {
+				pv[node.placeholder] = strings.Join(split[i:], "/")
+				return node
+			}
+			pv[node.placeholder] = split[i]
+		}
+		n = node
+	}
+	if !n.mapped {
+		return nil
+	}
+	return n
+}
+
+func BenchmarkStaticLookupGreedy(b *testing.B) {
+	root := &greedyNode{children: map[string]*greedyNode{}}
+	routes := benchmarkRoutes()
+	for _, r := range routes {
+		root.insert(r)
+	}
+	pv := map[string]string{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if root.match(routes[i%len(routes)], pv) == nil {
+			b.Fatal("not matched")
+		}
+	}
+}
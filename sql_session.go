@@ -9,6 +9,7 @@ import (
 type SQLSession struct {
 	Transactional bool
 	ctx           context.Context
+	cancel        context.CancelFunc
 	tx            *sql.Tx
 	db            *sql.DB
 	exec          executor
@@ -101,10 +102,17 @@ func (s *SQLSession) SwitchDataSource(source string) {
 	s.db = db
 }
 
+// release the timeout context, called when the request is finished
+func (s *SQLSession) close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
 func newSQLSession(timeout time.Duration, datasource *sql.DB) *SQLSession {
 	if timeout > 0 {
-		withTimeout, _ := context.WithTimeout(context.Background(), timeout)
-		return &SQLSession{ctx: withTimeout, db: datasource}
+		withTimeout, cancel := context.WithTimeout(context.Background(), timeout)
+		return &SQLSession{ctx: withTimeout, cancel: cancel, db: datasource}
 	} else {
 		return &SQLSession{ctx: context.Background(), db: datasource}
 	}
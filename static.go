@@ -0,0 +1,194 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// options of serving static files
+//
+//	eg: s.Static("/", "dist", StaticOptions{Precompressed: true, Fallback: "/index.html",
+//		CacheControl: map[string]string{".js": "public, max-age=31536000, immutable", "*": "no-cache"}})
+type StaticOptions struct {
+	// files served instead of the directory, eg: assets embedded in the binary
+	FileSystem http.FileSystem
+
+	// index file of directories, index.html by default
+	Index string
+
+	// list directories without an index file, disabled by default
+	Listing bool
+
+	// serve "name.gz" if present and accepted by the client
+	Precompressed bool
+
+	// Cache-Control by file extension, "*" applies to other files
+	CacheControl map[string]string
+
+	// file served for unknown paths without extension, eg: "/index.html" of a single page application
+	Fallback string
+}
+
+func (s *HttpServer) Static(prefix string, dir string, opts StaticOptions) RequestMapper {
+	prefix = joinPattern("", prefix)
+	s.mapRoute(routeScope{}, Get, joinPattern(prefix, "*"+mountVariable+"?"), staticHandler(dir, opts), staticHandler, nil)
+	return s
+}
+
+func (g *routeGroup) Static(prefix string, dir string, opts StaticOptions) RequestMapper {
+	g.mapRoute(Get, joinPattern(prefix, "*"+mountVariable+"?"), staticHandler(dir, opts), staticHandler, nil)
+	return g
+}
+
+func staticHandler(dir string, opts StaticOptions) RequestHandlerFunc {
+	fs := opts.FileSystem
+	if fs == nil {
+		fs = http.Dir(dir)
+	}
+	if opts.Index == "" {
+		opts.Index = "index.html"
+	}
+	return func(ctx *RequestCtx) interface{} {
+		name := path.Clean("/" + ctx.PathVariable[mountVariable])
+		f, info, e := openFile(fs, name)
+		if e != nil && opts.Fallback != "" && path.Ext(name) == "" {
+			name = path.Clean("/" + opts.Fallback)
+			f, info, e = openFile(fs, name)
+		}
+		if e != nil {
+			return NotFound("%s not found", ctx.Request.URL.Path)
+		}
+		defer f.Close()
+
+		if info.IsDir() {
+			// relative links of the index resolve against the directory
+			// the redirect is relative so the request path can not make it point to another host
+			if !strings.HasSuffix(ctx.Request.URL.Path, "/") {
+				redirectPath(ctx.ResponseWriter, ctx.Request, "./"+path.Base(ctx.Request.URL.EscapedPath())+"/")
+				return nil
+			}
+			index, indexInfo, e := openFile(fs, path.Join(name, opts.Index))
+			if e == nil && !indexInfo.IsDir() {
+				defer index.Close()
+				name, f, info = path.Join(name, opts.Index), index, indexInfo
+			} else if opts.Listing {
+				return listDirectory(ctx, f)
+			} else {
+				return NotFound("%s not found", ctx.Request.URL.Path)
+			}
+		}
+
+		wr := ctx.ResponseWriter
+		if cc := cacheControl(opts.CacheControl, name); cc != "" {
+			wr.Header().Set("Cache-Control", cc)
+		}
+		if opts.Precompressed {
+			wr.Header().Add("Vary", "Accept-Encoding")
+			if acceptsGzip(ctx.Request) {
+				if gz, gzInfo, e := openFile(fs, name+".gz"); e == nil && !gzInfo.IsDir() {
+					defer gz.Close()
+					f, info = gz, gzInfo
+					wr.Header().Set("Content-Encoding", "gzip")
+				}
+			}
+		}
+		wr.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+
+		// conditional requests and ranges are handled by ServeContent, the content type is detected by the original name
+		http.ServeContent(wr, ctx.Request, name, info.ModTime(), f)
+		return nil
+	}
+}
+
+func openFile(fs http.FileSystem, name string) (http.File, os.FileInfo, error) {
+	f, e := fs.Open(name)
+	if e != nil {
+		return nil, nil, e
+	}
+	info, e := f.Stat()
+	if e != nil {
+		_ = f.Close()
+		return nil, nil, e
+	}
+	return f, info, nil
+}
+
+func cacheControl(rules map[string]string, name string) string {
+	if cc, ok := rules[strings.ToLower(path.Ext(name))]; ok {
+		return cc
+	}
+	return rules["*"]
+}
+
+// gzip is accepted unless its quality is 0
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(encoding, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), "gzip") {
+			continue
+		}
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if q, e := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); e == nil && q <= 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+var listingPage = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<pre>
+{{range .Files}}<a href="{{.Href}}">{{.Name}}</a>
+{{end}}</pre>
+</body>
+</html>
+`))
+
+func listDirectory(ctx *RequestCtx, dir http.File) interface{} {
+	infos, e := dir.Readdir(-1)
+	if e != nil {
+		return InternalServerError("failed to read directory")
+	}
+	type file struct {
+		Name string
+		Href string
+	}
+	files := make([]file, 0, len(infos))
+	for _, info := range infos {
+		f := file{Name: info.Name(), Href: "./" + url.PathEscape(info.Name())}
+		if info.IsDir() {
+			f.Name += "/"
+			f.Href += "/"
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	wr := ctx.ResponseWriter
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if e := listingPage.Execute(wr, map[string]interface{}{"Path": ctx.Request.URL.Path, "Files": files}); e != nil {
+		logger.Error("IO Error occurs at response -", e.Error())
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// directory of static files, removed by the returned function
+func staticDir(t *testing.T) (string, func()) {
+	dir, e := ioutil.TempDir("", "kinoko-static")
+	if e != nil {
+		t.Fatal(e)
+	}
+	for name, content := range map[string]string{
+		"index.html":     "<p>home</p>",
+		"app.js":         "console.log('plain')",
+		"app.js.gz":      "gzipped",
+		"sub/index.html": "<p>sub</p>",
+		"docs/a.txt":     "a",
+		"docs/b c.txt":   "b",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if e := os.MkdirAll(filepath.Dir(p), 0755); e != nil {
+			t.Fatal(e)
+		}
+		if e := ioutil.WriteFile(p, []byte(content), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestStaticDirectoryRedirect(t *testing.T) {
+	dir, remove := staticDir(t)
+	defer remove()
+
+	s := newTestServer()
+	// paths are not redirected by the router, so the raw path reaches the handler
+	s.handlers.router = &RouterConfig{}
+	s.Static("/", dir, StaticOptions{})
+	s.Group("/assets").Static("/", dir, StaticOptions{})
+
+	for url, location := range map[string]string{
+		"/sub":              "./sub/",
+		"/sub?v=1":          "./sub/?v=1",
+		"//evil.com/../sub": "./sub/",
+		"/assets/sub":       "./sub/",
+		"/assets":           "./assets/",
+	} {
+		w := serve(s, "GET", url, nil)
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != location {
+			t.Errorf("%s: %d %q, expected %q", url, w.Code, w.Header().Get("Location"), location)
+		}
+	}
+	if w := serve(s, "GET", "/sub/", nil); w.Body.String() != "<p>sub</p>" {
+		t.Errorf("/sub/: %d %q", w.Code, w.Body.String())
+	}
+	if w := serve(s, "GET", "/docs/", nil); w.Code != http.StatusNotFound {
+		t.Errorf("directory without index is listed: %d", w.Code)
+	}
+}
+
+func TestStaticConditionalAndRange(t *testing.T) {
+	dir, remove := staticDir(t)
+	defer remove()
+
+	s := newTestServer()
+	s.Static("/", dir, StaticOptions{CacheControl: map[string]string{".js": "public, max-age=31536000", "*": "no-cache"}})
+
+	w := serve(s, "GET", "/app.js", nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Header().Get("Cache-Control") != "public, max-age=31536000" {
+		t.Fatalf("%d %q %q", w.Code, etag, w.Header().Get("Cache-Control"))
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/javascript") && !strings.HasPrefix(w.Header().Get("Content-Type"), "text/javascript") {
+		t.Errorf("content type: %s", w.Header().Get("Content-Type"))
+	}
+	if w := serve(s, "GET", "/app.js", http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("If-None-Match: %d %q", w.Code, w.Body.String())
+	}
+	if w := serve(s, "GET", "/app.js", http.Header{"If-None-Match": {`"other"`}}); w.Code != http.StatusOK {
+		t.Errorf("If-None-Match of another version: %d", w.Code)
+	}
+
+	w = serve(s, "GET", "/app.js", http.Header{"Range": {"bytes=0-6"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "console" || w.Header().Get("Content-Range") != "bytes 0-6/20" {
+		t.Errorf("Range: %d %q %q", w.Code, w.Body.String(), w.Header().Get("Content-Range"))
+	}
+	if w := serve(s, "GET", "/index.html", nil); w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("Cache-Control of other files: %q", w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestStaticPrecompressed(t *testing.T) {
+	dir, remove := staticDir(t)
+	defer remove()
+
+	s := newTestServer()
+	s.Static("/", dir, StaticOptions{Precompressed: true})
+
+	for encoding, expected := range map[string]string{
+		"gzip, deflate": "gzipped",
+		"br;q=1, gzip":  "gzipped",
+		"gzip;q=0, br":  "console.log('plain')",
+		"":              "console.log('plain')",
+	} {
+		w := serve(s, "GET", "/app.js", http.Header{"Accept-Encoding": {encoding}})
+		if w.Body.String() != expected || w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: %q, expected %q", encoding, w.Body.String(), expected)
+		}
+		if gzipped := w.Header().Get("Content-Encoding") == "gzip"; gzipped != (expected == "gzipped") {
+			t.Errorf("Accept-Encoding %q: Content-Encoding %q", encoding, w.Header().Get("Content-Encoding"))
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.Contains(ct, "javascript") {
+			t.Errorf("Accept-Encoding %q: content type %q", encoding, ct)
+		}
+	}
+	// files without variant are served as they are
+	if w := serve(s, "GET", "/index.html", http.Header{"Accept-Encoding": {"gzip"}}); w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("index.html: %q", w.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestStaticFallback(t *testing.T) {
+	dir, remove := staticDir(t)
+	defer remove()
+
+	s := newTestServer()
+	s.Static("/", dir, StaticOptions{Fallback: "/index.html"})
+
+	for url, expected := range map[string]int{
+		"/users/7":     http.StatusOK,
+		"/settings":    http.StatusOK,
+		"/missing.js":  http.StatusNotFound,
+		"/docs/c.txt":  http.StatusNotFound,
+		"/docs/a.txt":  http.StatusOK,
+		"/../../index": http.StatusMovedPermanently,
+	} {
+		if w := serve(s, "GET", url, nil); w.Code != expected {
+			t.Errorf("%s: %d, expected %d", url, w.Code, expected)
+		}
+	}
+	if w := serve(s, "GET", "/users/7", nil); w.Body.String() != "<p>home</p>" {
+		t.Errorf("fallback: %q", w.Body.String())
+	}
+}
+
+func TestStaticListing(t *testing.T) {
+	dir, remove := staticDir(t)
+	defer remove()
+
+	s := newTestServer()
+	s.Static("/files", dir, StaticOptions{Listing: true})
+
+	w := serve(s, "GET", "/files/docs/", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<a href="./a.txt">a.txt</a>`) || !strings.Contains(w.Body.String(), `<a href="./b%20c.txt">b c.txt</a>`) {
+		t.Errorf("%d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,331 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"fmt"
+	"strings"
+)
+
+// route tries by method
+type routeTable map[RequestMethod]*prefixNode
+
+// routes served by the handler, never modified once published
+// changes are made on a copy by a routeTx and published atomically, requests in flight keep the state they started with
+type routeState struct {
+	// tries by virtual host, nil for the default routes
+	tables map[*virtualHost]routeTable
+
+	// virtual hosts matched in order, literal hosts first
+	hosts []*virtualHost
+
+	// patterns of named routes by virtual host, nil for the default routes
+	names map[*virtualHost]map[string]string
+}
+
+var emptyRouteState = &routeState{tables: map[*virtualHost]routeTable{}, names: map[*virtualHost]map[string]string{}}
+
+// where a mapper maps routes, the zero value maps default routes published at once
+type routeScope struct {
+	host *virtualHost
+	tx   *routeTx
+}
+
+func (g *routeGroup) scope() routeScope {
+	return routeScope{host: g.host, tx: g.tx}
+}
+
+// pending changes of the routes, published by commit
+type routeTx struct {
+	state *routeState
+
+	// nodes copied by this transaction, modified in place
+	owned map[*prefixNode]bool
+
+	errors []error
+}
+
+// published routes
+func (c *RequestHandler) current() *routeState {
+	if st, ok := c.published.Load().(*routeState); ok {
+		return st
+	}
+	return emptyRouteState
+}
+
+// start changing the routes, changes are serialized until commit or rollback
+func (c *RequestHandler) begin() *routeTx {
+	c.mappingLock.Lock()
+	current := c.current()
+	st := &routeState{
+		tables: make(map[*virtualHost]routeTable, len(current.tables)),
+		hosts:  append([]*virtualHost{}, current.hosts...),
+		names:  make(map[*virtualHost]map[string]string, len(current.names)),
+	}
+	for host, table := range current.tables {
+		copied := make(routeTable, len(table))
+		for method, root := range table {
+			copied[method] = root
+		}
+		st.tables[host] = copied
+	}
+	for host, names := range current.names {
+		copied := make(map[string]string, len(names))
+		for name, pattern := range names {
+			copied[name] = pattern
+		}
+		st.names[host] = copied
+	}
+	return &routeTx{state: st, owned: map[*prefixNode]bool{}}
+}
+
+func (c *RequestHandler) commit(tx *routeTx) {
+	c.published.Store(tx.state)
+	c.mappingLock.Unlock()
+}
+
+func (c *RequestHandler) rollback(tx *routeTx) {
+	c.mappingLock.Unlock()
+}
+
+// log the mapping error and keep it, nil is ignored
+func (tx *routeTx) fail(e error) {
+	if e != nil {
+		logger.Error(e)
+		tx.errors = append(tx.errors, e)
+	}
+}
+
+// copy of the node owned by the transaction, nil stays nil
+func (tx *routeTx) own(n *prefixNode) *prefixNode {
+	if n == nil || tx.owned[n] {
+		return n
+	}
+	copied := *n
+	copied.children = make(map[string]*prefixNode, len(n.children))
+	for k, child := range n.children {
+		copied.children[k] = child
+	}
+	copied.params = append([]*prefixNode{}, n.params...)
+	tx.owned[&copied] = true
+	return &copied
+}
+
+// node allocated by the transaction
+func (tx *routeTx) fresh(n *prefixNode) *prefixNode {
+	tx.owned[n] = true
+	return n
+}
+
+// owned trie root of the method, allocated on first use
+func (tx *routeTx) root(host *virtualHost, method RequestMethod) *prefixNode {
+	table := tx.state.tables[host]
+	if table == nil {
+		table = routeTable{}
+		tx.state.tables[host] = table
+	}
+	root := tx.own(table[method])
+	if root == nil {
+		root = tx.fresh(&prefixNode{prefix: "", children: map[string]*prefixNode{}})
+	}
+	table[method] = root
+	return root
+}
+
+// owned nodes from the root to the node of the pattern, nil if the pattern has no node
+// segments are compared as they are written, eg: ":id<int>" does not find ":id"
+func (tx *routeTx) path(host *virtualHost, method RequestMethod, pattern string) []*prefixNode {
+	table := tx.state.tables[host]
+	if table == nil || table[method] == nil {
+		return nil
+	}
+	nodes := []*prefixNode{tx.root(host, method)}
+	if pattern == "" {
+		return nodes
+	}
+	for _, raw := range strings.Split(pattern, "/") {
+		seg := parseSegment(raw)
+		current := nodes[len(nodes)-1]
+		var next *prefixNode
+		switch seg.kind {
+		case catchAllSegment:
+			if current.catchAll != nil && current.catchAll.prefix == seg.String() {
+				next = tx.own(current.catchAll)
+				current.catchAll = next
+			}
+		case paramSegment:
+			for n, p := range current.params {
+				if p.prefix == seg.String() {
+					next = tx.own(p)
+					current.params[n] = next
+				}
+			}
+		default:
+			if child := current.children[seg.value]; child != nil {
+				next = tx.own(child)
+				current.children[seg.value] = next
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		nodes = append(nodes, next)
+	}
+	return nodes
+}
+
+// remove the route, a pattern with optional segments removes every route it mapped
+func (tx *routeTx) remove(host *virtualHost, method RequestMethod, pattern string) error {
+	for _, p := range expandOptional(pattern) {
+		nodes := tx.path(host, method, p)
+		if len(nodes) == 0 || !nodes[len(nodes)-1].mapped {
+			return fmt.Errorf("%s /%s: route is not mapped", method, p)
+		}
+		node := nodes[len(nodes)-1]
+		node.mapped, node.handler, node.handlerName = false, nil, ""
+		node.interceptors, node.selected, node.properties = nil, nil, nil
+		tx.prune(host, method, nodes)
+		logger.Info("URL Unmapped", method, "/"+p)
+	}
+	tx.forget(host, pattern)
+	return nil
+}
+
+// remove the routes of every method below the prefix, including the prefix itself
+func (tx *routeTx) removePrefix(host *virtualHost, prefix string) {
+	prefix = strings.Trim(prefix, "/")
+	for method := range tx.state.tables[host] {
+		nodes := tx.path(host, method, prefix)
+		if len(nodes) == 0 {
+			continue
+		}
+		node := nodes[len(nodes)-1]
+		node.mapped, node.handler, node.handlerName = false, nil, ""
+		node.interceptors, node.selected, node.properties = nil, nil, nil
+		node.children, node.params, node.catchAll = map[string]*prefixNode{}, nil, nil
+		tx.prune(host, method, nodes)
+	}
+	names := tx.state.names[host]
+	for name, pattern := range names {
+		if prefix == "" || pattern == "/"+prefix || strings.HasPrefix(pattern, "/"+prefix+"/") {
+			delete(names, name)
+		}
+	}
+}
+
+// detach nodes left without routes, from the end of the path
+func (tx *routeTx) prune(host *virtualHost, method RequestMethod, nodes []*prefixNode) {
+	for i := len(nodes) - 1; i > 0; i-- {
+		node, parent := nodes[i], nodes[i-1]
+		if node.mapped || len(node.children) > 0 || len(node.params) > 0 || node.catchAll != nil {
+			return
+		}
+		switch {
+		case parent.catchAll == node:
+			parent.catchAll = nil
+		case parent.children[node.prefix] == node:
+			delete(parent.children, node.prefix)
+		default:
+			for n, p := range parent.params {
+				if p == node {
+					parent.params = append(parent.params[:n], parent.params[n+1:]...)
+					break
+				}
+			}
+		}
+	}
+	if root := nodes[0]; !root.mapped && len(root.children) == 0 && len(root.params) == 0 && root.catchAll == nil {
+		delete(tx.state.tables[host], method)
+	}
+}
+
+// forget the names of the pattern on the host once no method maps it
+func (tx *routeTx) forget(host *virtualHost, pattern string) {
+	p := expandOptional(pattern)[0]
+	for method := range tx.state.tables[host] {
+		if nodes := tx.path(host, method, p); len(nodes) > 0 && nodes[len(nodes)-1].mapped {
+			return
+		}
+	}
+	pattern = "/" + strings.TrimPrefix(pattern, "/")
+	names := tx.state.names[host]
+	for name, named := range names {
+		if named == pattern {
+			delete(names, name)
+		}
+	}
+}
+
+// remove a route mapped by the server, the change is published at once
+func (s *HttpServer) Unmap(method RequestMethod, pattern string) error {
+	return s.unmapRoute(routeScope{}, method, pattern)
+}
+
+func (g *routeGroup) Unmap(method RequestMethod, pattern string) error {
+	return g.server.unmapRoute(g.scope(), method, joinPattern(g.prefix, pattern))
+}
+
+func (s *HttpServer) unmapRoute(scope routeScope, method RequestMethod, pattern string) error {
+	method = RequestMethod(strings.TrimSpace(string(method)))
+	pattern = strings.TrimSpace(pattern)
+	if scope.tx != nil {
+		return scope.tx.remove(scope.host, method, pattern)
+	}
+	tx := s.handlers.begin()
+	if e := tx.remove(scope.host, method, pattern); e != nil {
+		s.handlers.rollback(tx)
+		return e
+	}
+	s.handlers.commit(tx)
+	return nil
+}
+
+// map and unmap routes by the mapper and publish them at once
+// nothing is published if any route fails to map, the mapping errors are returned
+// the server must not be mapped directly inside fn, use the mapper instead
+//
+//	eg: s.Batch(func(m RequestMapper) { _ = m.Unmap(Get, "/v1/users"); m.GET("/v2/users", handler) })
+func (s *HttpServer) Batch(fn func(mapper RequestMapper)) error {
+	tx := s.handlers.begin()
+	s.runBatch(tx, fn)
+	return s.finishBatch(tx)
+}
+
+// replace every default route below the prefix by the routes mapped by fn at once
+// the mapper is a group of the prefix, nothing is replaced if any route fails to map
+//
+//	eg: s.Replace("/plugins/report", plugin.Mapping)
+func (s *HttpServer) Replace(prefix string, fn func(mapper RequestMapper)) error {
+	tx := s.handlers.begin()
+	tx.removePrefix(nil, prefix)
+	s.runBatch(tx, func(mapper RequestMapper) {
+		fn(mapper.Group(prefix))
+	})
+	return s.finishBatch(tx)
+}
+
+// the transaction is rolled back if fn panics
+func (s *HttpServer) runBatch(tx *routeTx, fn func(mapper RequestMapper)) {
+	g := newRouteGroup(s, nil, "", nil)
+	g.tx = tx
+	defer func() {
+		if err := recover(); err != nil {
+			s.handlers.rollback(tx)
+			panic(err)
+		}
+	}()
+	fn(g)
+}
+
+func (s *HttpServer) finishBatch(tx *routeTx) error {
+	if len(tx.errors) > 0 {
+		s.handlers.rollback(tx)
+		return fmt.Errorf("%d routes can not be mapped, first: %v", len(tx.errors), tx.errors[0])
+	}
+	s.handlers.commit(tx)
+	return nil
+}
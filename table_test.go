@@ -0,0 +1,207 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUnmap(t *testing.T) {
+	s := newTestServer()
+	s.GET("/users/:id?", text("user"), NewProperty(RouteNameProperty, "user"))
+	s.POST("/users", text("create"))
+	api := s.Group("/api")
+	api.GET("/items", text("items"))
+
+	if e := s.Unmap(Get, "/users/:id?"); e != nil {
+		t.Fatal(e)
+	}
+	for url, expected := range map[string]int{"/users/1": 404, "/users": 405, "/api/items": 200} {
+		if w := serve(s, "GET", url, nil); w.Code != expected {
+			t.Errorf("GET %s: %d, expected %d", url, w.Code, expected)
+		}
+	}
+	if w := serve(s, "POST", "/users", nil); w.Body.String() != "create" {
+		t.Errorf("POST /users: %d", w.Code)
+	}
+	if _, e := s.URLFor("user", nil, nil); e == nil {
+		t.Error("name of the unmapped route is kept")
+	}
+	if e := s.Unmap(Get, "/users/:id?"); e == nil || !strings.Contains(e.Error(), "route is not mapped") {
+		t.Errorf("unmapped twice: %v", e)
+	}
+
+	// patterns of groups are relative to their prefix
+	if e := api.Unmap(Get, "/items"); e != nil {
+		t.Fatal(e)
+	}
+	if w := serve(s, "GET", "/api/items", nil); w.Code != 404 {
+		t.Errorf("GET /api/items: %d", w.Code)
+	}
+	// the route can be mapped again
+	s.GET("/users/:id", text("again"))
+	if w := serve(s, "GET", "/users/1", nil); w.Body.String() != "again" {
+		t.Errorf("mapped again: %q", w.Body.String())
+	}
+}
+
+func TestBatch(t *testing.T) {
+	s := newTestServer()
+	s.GET("/v1/users", text("v1"))
+
+	before := s.handlers.current()
+	if e := s.Batch(func(m RequestMapper) {
+		if e := m.Unmap(Get, "/v1/users"); e != nil {
+			t.Error(e)
+		}
+		m.GET("/v2/users", text("v2"))
+		// nothing is published before the batch ends
+		if s.handlers.current() != before {
+			t.Error("published before the end of the batch")
+		}
+	}); e != nil {
+		t.Fatal(e)
+	}
+	if w := serve(s, "GET", "/v1/users", nil); w.Code != 404 {
+		t.Errorf("GET /v1/users: %d", w.Code)
+	}
+	if w := serve(s, "GET", "/v2/users", nil); w.Body.String() != "v2" {
+		t.Errorf("GET /v2/users: %q", w.Body.String())
+	}
+	// published states are never modified
+	if before.tables[nil].match(Get, "/v1/users", map[string]string{}, false) == nil {
+		t.Error("previous state is modified")
+	}
+}
+
+func TestBatchRollback(t *testing.T) {
+	s := newTestServer()
+	s.GET("/users", text("users"))
+	before := s.handlers.current()
+
+	e := s.Batch(func(m RequestMapper) {
+		_ = m.Unmap(Get, "/users")
+		m.GET("/posts", text("posts"))
+		m.GET("/posts", text("conflict"))
+	})
+	if e == nil || !strings.Contains(e.Error(), "route is already mapped") {
+		t.Errorf("conflicting batch: %v", e)
+	}
+	if s.handlers.current() != before {
+		t.Error("failed batch is published")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("panic of the batch is not propagated")
+			}
+		}()
+		_ = s.Batch(func(m RequestMapper) {
+			_ = m.Unmap(Get, "/users")
+			panic("failed")
+		})
+	}()
+	if s.handlers.current() != before {
+		t.Error("panicking batch is published")
+	}
+
+	// the lock is released by the rollbacks
+	s.GET("/posts", text("posts"))
+	for url, expected := range map[string]string{"/users": "users", "/posts": "posts"} {
+		if w := serve(s, "GET", url, nil); w.Body.String() != expected {
+			t.Errorf("GET %s: %q", url, w.Body.String())
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	s := newTestServer()
+	s.GET("/plugins/report", text("old"), NewProperty(RouteNameProperty, "report"))
+	s.GET("/plugins/report/daily", text("old daily"))
+	s.POST("/plugins/report/export", text("old export"))
+	s.GET("/plugins/reports", text("other"))
+
+	if e := s.Replace("/plugins/report", func(m RequestMapper) {
+		m.GET("/", text("new"))
+		m.GET("/weekly", text("new weekly"))
+	}); e != nil {
+		t.Fatal(e)
+	}
+	for url, expected := range map[string]int{
+		"/plugins/report":        200,
+		"/plugins/report/weekly": 200,
+		"/plugins/report/daily":  404,
+		"/plugins/report/export": 404,
+		"/plugins/reports":       200,
+	} {
+		if w := serve(s, "GET", url, nil); w.Code != expected {
+			t.Errorf("GET %s: %d, expected %d", url, w.Code, expected)
+		}
+	}
+	if _, e := s.URLFor("report", nil, nil); e == nil {
+		t.Error("name of the replaced route is kept")
+	}
+
+	// nothing is replaced if the new routes fail
+	if e := s.Replace("/plugins/report", func(m RequestMapper) {
+		m.GET("/:id<[>", text("invalid"))
+	}); e == nil {
+		t.Error("invalid replacement is accepted")
+	}
+	if w := serve(s, "GET", "/plugins/report/weekly", nil); w.Body.String() != "new weekly" {
+		t.Errorf("failed replacement is published: %q", w.Body.String())
+	}
+}
+
+// run with -race, requests are served while routes are mapped and unmapped
+func TestConcurrentMapping(t *testing.T) {
+	s := newTestServer()
+	s.GET("/stable", text("stable"))
+	admin := s.Group("/admin")
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				pattern := fmt.Sprintf("/dynamic/%d/%d", w, i)
+				s.GET(pattern, text("dynamic"))
+				admin.Group(fmt.Sprintf("/%d", w)).GET(fmt.Sprintf("/%d", i), text("admin"))
+				if e := s.Unmap(Get, pattern); e != nil {
+					t.Error(e)
+				}
+				_ = s.Batch(func(m RequestMapper) {
+					m.GET(pattern, text("batched"))
+				})
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if w := serve(s, "GET", "/stable", nil); w.Code != http.StatusOK {
+					t.Errorf("GET /stable: %d", w.Code)
+				}
+				serve(s, "GET", fmt.Sprintf("/dynamic/0/%d", i%50), nil)
+				_ = s.Routes()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(s.Routes()); n != 1+4*50*2 {
+		t.Errorf("%d routes", n)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// property key naming a route, the name is used to generate its url by URLFor
+// a name can be shared by several methods of the same pattern, names of virtual hosts are their own
+//
+//	eg: s.GET("/users/:id", handler, NewProperty(RouteNameProperty, "user"))
+const RouteNameProperty = "name"
+
+// value of the last property of the key, nil if absent
+func propertyValue(properties []HandlerProperties, k string) interface{} {
+	var v interface{}
+	for _, p := range properties {
+		if p.k == k {
+			v = p.v
+		}
+	}
+	return v
+}
+
+func (tx *routeTx) nameRoute(host *virtualHost, name string, pattern string) error {
+	pattern = "/" + strings.TrimPrefix(pattern, "/")
+	names := tx.state.names[host]
+	if names == nil {
+		names = map[string]string{}
+		tx.state.names[host] = names
+	}
+	if p, ok := names[name]; ok && p != pattern {
+		return fmt.Errorf("route name %s is used by %s and %s", name, p, pattern)
+	}
+	names[name] = pattern
+	return nil
+}
+
+// generate the url of a named default route, placeholders are filled by params and escaped
+// missing optional placeholders are omitted, others fail the generation
+//
+//	eg: URLFor("user", map[string]string{"id": "1"}, url.Values{"tab": {"posts"}}) => "/users/1?tab=posts"
+func (s *HttpServer) URLFor(name string, params map[string]string, query url.Values) (string, error) {
+	return s.handlers.urlFor(nil, name, params, query)
+}
+
+// generate the url of a named route of the virtual host serving the request, see HttpServer.URLFor
+func (c *RequestCtx) URLFor(name string, params map[string]string, query url.Values) (string, error) {
+	if c.handlers == nil {
+		return "", fmt.Errorf("route %s is not found", name)
+	}
+	return c.handlers.urlFor(c.host, name, params, query)
+}
+
+func (c *RequestHandler) urlFor(host *virtualHost, name string, params map[string]string, query url.Values) (string, error) {
+	pattern, ok := c.current().names[host][name]
+	if !ok {
+		return "", fmt.Errorf("route %s is not found", name)
+	}
+
+	var segments []string
+	for _, raw := range strings.Split(pattern[1:], "/") {
+		seg := parseSegment(raw)
+		if seg.kind == staticSegment {
+			segments = append(segments, seg.value)
+			continue
+		}
+
+		value := params[seg.value]
+		if value == "" {
+			if seg.optional {
+				continue
+			}
+			return "", fmt.Errorf("route %s: missing parameter %s", name, seg.value)
+		}
+		matcher, e := compileConstraint(seg.constraint)
+		if e != nil {
+			return "", fmt.Errorf("route %s: %v", name, e)
+		}
+
+		if seg.kind == catchAllSegment {
+			if matcher != nil && !matcher(value) {
+				return "", fmt.Errorf("route %s: parameter %s does not match <%s>", name, seg.value, seg.constraint)
+			}
+			// slashes of catch-all values are kept
+			parts := strings.Split(strings.TrimPrefix(value, "/"), "/")
+			for i, part := range parts {
+				if isDotSegment(part) {
+					return "", fmt.Errorf("route %s: parameter %s must not contain dot segments", name, seg.value)
+				}
+				parts[i] = url.PathEscape(part)
+			}
+			segments = append(segments, strings.Join(parts, "/"))
+			continue
+		}
+
+		if strings.Contains(value, "/") {
+			return "", fmt.Errorf("route %s: parameter %s must not contain '/'", name, seg.value)
+		}
+		if isDotSegment(value) {
+			return "", fmt.Errorf("route %s: parameter %s must not be a dot segment", name, seg.value)
+		}
+		if matcher != nil && !matcher(value) {
+			return "", fmt.Errorf("route %s: parameter %s does not match <%s>", name, seg.value, seg.constraint)
+		}
+		segments = append(segments, url.PathEscape(value))
+	}
+
+	u := "/" + strings.Join(segments, "/")
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u, nil
+}
+
+// "." and ".." are not escaped by url.PathEscape and would be resolved by clients
+func isDotSegment(s string) bool {
+	return s == "." || s == ".."
+}
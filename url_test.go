@@ -0,0 +1,142 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestURLFor(t *testing.T) {
+	s := newTestServer()
+	s.GET("/users/:id<int>/posts/:slug?", text("post"), NewProperty(RouteNameProperty, "post"))
+	s.GET("/files/*path", text("file"), NewProperty(RouteNameProperty, "file"))
+	s.GET("/", text("home"), NewProperty(RouteNameProperty, "home"))
+
+	for _, c := range []struct {
+		name     string
+		params   map[string]string
+		query    url.Values
+		expected string
+	}{
+		{"home", nil, nil, "/"},
+		{"post", map[string]string{"id": "1", "slug": "hello world"}, nil, "/users/1/posts/hello%20world"},
+		{"post", map[string]string{"id": "1"}, url.Values{"tab": {"a&b"}}, "/users/1/posts?tab=a%26b"},
+		{"file", map[string]string{"path": "a/b c/d.txt"}, nil, "/files/a/b%20c/d.txt"},
+		{"file", map[string]string{"path": "a/..b"}, nil, "/files/a/..b"},
+	} {
+		if u, e := s.URLFor(c.name, c.params, c.query); e != nil || u != c.expected {
+			t.Errorf("%s %v: %q %v, expected %q", c.name, c.params, u, e, c.expected)
+		}
+	}
+}
+
+func TestURLForErrors(t *testing.T) {
+	s := newTestServer()
+	s.GET("/users/:id<int>/:tab?", text("user"), NewProperty(RouteNameProperty, "user"))
+	s.GET("/files/*path", text("file"), NewProperty(RouteNameProperty, "file"))
+
+	for _, c := range []struct {
+		name   string
+		params map[string]string
+		err    string
+	}{
+		{"missing", nil, "route missing is not found"},
+		{"user", nil, "missing parameter id"},
+		{"user", map[string]string{"id": "a"}, "does not match <int>"},
+		{"user", map[string]string{"id": "1/2"}, "must not contain '/'"},
+		{"user", map[string]string{"id": "1", "tab": ".."}, "must not be a dot segment"},
+		{"user", map[string]string{"id": "1", "tab": "."}, "must not be a dot segment"},
+		{"file", map[string]string{"path": "a/../../admin"}, "must not contain dot segments"},
+		{"file", map[string]string{"path": "./a"}, "must not contain dot segments"},
+	} {
+		if u, e := s.URLFor(c.name, c.params, nil); e == nil || !strings.Contains(e.Error(), c.err) {
+			t.Errorf("%s %v: %q %v, expected %q", c.name, c.params, u, e, c.err)
+		}
+	}
+}
+
+func TestRouteNames(t *testing.T) {
+	s := newTestServer()
+	s.GET("/users/:id", urlOf("user"), NewProperty(RouteNameProperty, "user"))
+	s.PUT("/users/:id", text("updated"), NewProperty(RouteNameProperty, "user"))
+	if len(s.mappingErrors) > 0 {
+		t.Fatal(s.mappingErrors)
+	}
+	if r := serve(s, "GET", "/users/7", nil); r.Body.String() != "/users/1" {
+		t.Errorf("RequestCtx.URLFor: %q", r.Body.String())
+	}
+
+	s.GET("/accounts/:id", text("account"), NewProperty(RouteNameProperty, "user"))
+	if len(s.mappingErrors) != 1 {
+		t.Errorf("mapping errors: %v", s.mappingErrors)
+	}
+}
+
+func urlOf(name string) RequestHandlerFunc {
+	return func(ctx *RequestCtx) interface{} {
+		u, e := ctx.URLFor(name, map[string]string{"id": "1"}, nil)
+		if e != nil {
+			return e.Error()
+		}
+		return u
+	}
+}
+
+func TestRouteNamesByHost(t *testing.T) {
+	s := newTestServer()
+	s.GET("/users/:id", urlOf("user"), NewProperty(RouteNameProperty, "user"))
+	s.Host("a.example.com").GET("/accounts/:id", urlOf("user"), NewProperty(RouteNameProperty, "user"))
+	s.Host("b.example.com").GET("/members/:id", urlOf("user"), NewProperty(RouteNameProperty, "user"))
+	s.Host("b.example.com").GET("/users/:id", text("b"), NewProperty(RouteNameProperty, "b-user"))
+	if len(s.mappingErrors) > 0 {
+		t.Fatal(s.mappingErrors)
+	}
+
+	for host, expected := range map[string]string{
+		"example.com":   "/users/1",
+		"a.example.com": "/accounts/1",
+		"b.example.com": "/members/1",
+	} {
+		r := serve(s, "GET", "http://"+host+expected, nil)
+		if r.Code != http.StatusOK || r.Body.String() != expected {
+			t.Errorf("%s: %d %q, expected %s", host, r.Code, r.Body.String(), expected)
+		}
+	}
+	if u, e := s.URLFor("user", map[string]string{"id": "1"}, nil); u != "/users/1" {
+		t.Errorf("default route: %q %v", u, e)
+	}
+
+	// unmapping the pattern of a host keeps the names of other hosts
+	if e := s.Host("b.example.com").Unmap(Get, "/users/:id"); e != nil {
+		t.Fatal(e)
+	}
+	if u, e := s.URLFor("user", map[string]string{"id": "1"}, nil); u != "/users/1" {
+		t.Errorf("default route after unmapping b.example.com: %q %v", u, e)
+	}
+	if e := s.Unmap(Get, "/users/:id"); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := s.URLFor("user", nil, nil); e == nil {
+		t.Error("name of the unmapped route is kept")
+	}
+	if r := serve(s, "GET", "http://a.example.com/accounts/1", nil); r.Body.String() != "/accounts/1" {
+		t.Errorf("a.example.com after unmapping the default route: %q", r.Body.String())
+	}
+}
+
+func TestRouteNameConflict(t *testing.T) {
+	s := newTestServer()
+	h := s.Host("a.example.com")
+	h.GET("/users/:id", text("a"), NewProperty(RouteNameProperty, "user"))
+	h.GET("/accounts/:id", text("b"), NewProperty(RouteNameProperty, "user"))
+	if len(s.mappingErrors) != 1 {
+		t.Errorf("mapping errors: %v", s.mappingErrors)
+	}
+}
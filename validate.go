@@ -0,0 +1,347 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// custom validation rule used by the validate tag, register it as a kinoko spore
+//
+//	eg: `validate:"required,phone=cn"` calls the rule named "phone" with param "cn"
+type ValidationRule interface {
+	Rule() string
+
+	// returns a message if the value is invalid, empty if valid
+	// the value is dereferenced, nil pointers are only checked by "required"
+	Validate(value interface{}, param string) string
+}
+
+// validation rule implemented by a function
+type ValidationRuleFunc struct {
+	Name string
+	Func func(value interface{}, param string) string
+}
+
+func (r *ValidationRuleFunc) Rule() string {
+	return r.Name
+}
+
+func (r *ValidationRuleFunc) Validate(value interface{}, param string) string {
+	return r.Func(value, param)
+}
+
+var validation = struct {
+	sync.RWMutex
+	rules map[string]ValidationRule
+	plans map[reflect.Type][]fieldValidation
+}{
+	rules: map[string]ValidationRule{},
+	plans: map[reflect.Type][]fieldValidation{},
+}
+
+func init() {
+	for _, r := range []*ValidationRuleFunc{
+		{"required", validateRequired},
+		{"min", validateMin},
+		{"max", validateMax},
+		{"len", validateLen},
+		{"email", validateEmail},
+		{"oneof", validateOneOf},
+	} {
+		RegisterValidationRule(r)
+	}
+}
+
+// register a rule, any rule with the same name is replaced
+func RegisterValidationRule(rule ValidationRule) {
+	validation.Lock()
+	validation.rules[rule.Rule()] = rule
+	validation.plans = map[reflect.Type][]fieldValidation{}
+	validation.Unlock()
+}
+
+type fieldRule struct {
+	name  string
+	param string
+	rule  ValidationRule
+}
+
+type fieldValidation struct {
+	index  int
+	name   string
+	source string
+	rules  []fieldRule
+
+	// struct, pointer to struct or slice of them, validated recursively
+	nested bool
+}
+
+// validate a struct by its validate tags, including nested structs and slices
+// returns a *BindingError listing every invalid field, nil if valid, or an error if a rule is unknown
+func Validate(v interface{}) error {
+	if e := checkValidation(reflect.TypeOf(v), map[reflect.Type]bool{}); e != nil {
+		return e
+	}
+	var errs []FieldError
+	validateValue(reflect.ValueOf(v), "", "", &errs)
+	if len(errs) > 0 {
+		return &BindingError{Message: "validation failed", Errors: errs}
+	}
+	return nil
+}
+
+func validateValue(v reflect.Value, path string, source string, errs *[]FieldError) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), source, errs)
+		}
+	case reflect.Struct:
+		// types are checked before their values are validated
+		plan, e := validationPlan(v.Type())
+		if e != nil {
+			return
+		}
+		for _, f := range plan {
+			name := f.name
+			if path != "" {
+				name = path + "." + name
+			}
+			src := source
+			if f.source != "" {
+				src = f.source
+			}
+			field := v.Field(f.index)
+			if validateField(field, name, src, f.rules, errs) && f.nested {
+				validateValue(field, name, src, errs)
+			}
+		}
+	}
+}
+
+// returns false if the field is invalid
+func validateField(field reflect.Value, name string, source string, rules []fieldRule, errs *[]FieldError) bool {
+	value := field
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	for _, r := range rules {
+		if r.name != "required" && value.Kind() == reflect.Ptr {
+			continue
+		}
+		var message string
+		if r.name == "required" {
+			message = r.rule.Validate(field.Interface(), r.param)
+		} else {
+			message = r.rule.Validate(value.Interface(), r.param)
+		}
+		if message != "" {
+			*errs = append(*errs, FieldError{Field: name, Source: source, Rule: r.name, Message: message})
+			return false
+		}
+	}
+	return true
+}
+
+// fields to validate of the type, resolved once
+// an unknown rule fails the plan, typed handlers are checked when they are mapped
+func validationPlan(t reflect.Type) ([]fieldValidation, error) {
+	validation.RLock()
+	plan, ok := validation.plans[t]
+	validation.RUnlock()
+	if ok {
+		return plan, nil
+	}
+
+	validation.Lock()
+	defer validation.Unlock()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		fv := fieldValidation{index: i, name: f.Name, nested: isNested(f.Type)}
+		for _, key := range []string{"json", "form"} {
+			if name := strings.Split(f.Tag.Get(key), ",")[0]; name != "" && name != "-" {
+				fv.name = name
+				break
+			}
+		}
+		for _, source := range []string{"path", "query", "header"} {
+			if name, ok := f.Tag.Lookup(source); ok {
+				fv.name, fv.source = name, source
+				break
+			}
+		}
+		if tag := f.Tag.Get("validate"); tag != "" && tag != "-" {
+			for _, r := range strings.Split(tag, ",") {
+				kv := strings.SplitN(strings.TrimSpace(r), "=", 2)
+				rule := validation.rules[kv[0]]
+				if rule == nil {
+					return nil, fmt.Errorf("unknown validation rule '%s' of %v.%s", kv[0], t, f.Name)
+				}
+				fr := fieldRule{name: kv[0], rule: rule}
+				if len(kv) > 1 {
+					fr.param = kv[1]
+				}
+				fv.rules = append(fv.rules, fr)
+			}
+		}
+		if len(fv.rules) > 0 || fv.nested {
+			plan = append(plan, fv)
+		}
+	}
+	validation.plans[t] = plan
+	return plan, nil
+}
+
+// resolve the plans of the type and of the nested types, returns the first unknown rule
+func checkValidation(t reflect.Type, checked map[reflect.Type]bool) error {
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || checked[t] {
+		return nil
+	}
+	checked[t] = true
+	plan, e := validationPlan(t)
+	if e != nil {
+		return e
+	}
+	for _, f := range plan {
+		if f.nested {
+			if e := checkValidation(t.Field(f.index).Type, checked); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+func isNested(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+func validateRequired(value interface{}, _ string) string {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return "is required"
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return "is required"
+		}
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		if v.Len() == 0 {
+			return "is required"
+		}
+	default:
+		if reflect.DeepEqual(value, reflect.Zero(v.Type()).Interface()) {
+			return "is required"
+		}
+	}
+	return ""
+}
+
+// length of strings and containers, value of numbers
+func measure(value interface{}) (float64, bool, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		return float64(len([]rune(v.String()))), true, true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false, true
+	}
+	return 0, false, false
+}
+
+func compare(value interface{}, param string, rule string, ok func(n float64, limit float64) bool) string {
+	limit, e := strconv.ParseFloat(param, 64)
+	if e != nil {
+		return fmt.Sprintf("invalid %s parameter '%s'", rule, param)
+	}
+	n, length, valid := measure(value)
+	if !valid {
+		return fmt.Sprintf("%s is not supported for %T", rule, value)
+	}
+	if ok(n, limit) {
+		return ""
+	}
+	switch {
+	case length && rule == "min":
+		return fmt.Sprintf("length must be at least %s", param)
+	case length && rule == "max":
+		return fmt.Sprintf("length must be at most %s", param)
+	case length:
+		return fmt.Sprintf("length must be %s", param)
+	case rule == "min":
+		return fmt.Sprintf("must be at least %s", param)
+	case rule == "max":
+		return fmt.Sprintf("must be at most %s", param)
+	default:
+		return fmt.Sprintf("must be %s", param)
+	}
+}
+
+func validateMin(value interface{}, param string) string {
+	return compare(value, param, "min", func(n float64, limit float64) bool { return n >= limit })
+}
+
+func validateMax(value interface{}, param string) string {
+	return compare(value, param, "max", func(n float64, limit float64) bool { return n <= limit })
+}
+
+func validateLen(value interface{}, param string) string {
+	return compare(value, param, "len", func(n float64, limit float64) bool { return n == limit })
+}
+
+var emailRegexp = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// empty strings are left to "required"
+func validateEmail(value interface{}, _ string) string {
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Sprintf("email is not supported for %T", value)
+	}
+	if s != "" && !emailRegexp.MatchString(s) {
+		return "must be a valid email address"
+	}
+	return ""
+}
+
+// oneof=a b c
+func validateOneOf(value interface{}, param string) string {
+	s := fmt.Sprint(value)
+	for _, option := range strings.Fields(param) {
+		if s == option {
+			return ""
+		}
+	}
+	return fmt.Sprintf("must be one of [%s]", param)
+}
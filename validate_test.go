@@ -0,0 +1,114 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type address struct {
+	City string `json:"city" validate:"required"`
+}
+
+type signupReq struct {
+	Name      string    `json:"name" validate:"required,min=2,max=8"`
+	Email     string    `json:"email" validate:"email"`
+	Age       *int      `json:"age" validate:"min=18"`
+	Role      string    `json:"role" validate:"oneof=user admin"`
+	Code      string    `json:"code" validate:"len=4"`
+	Addresses []address `json:"addresses"`
+}
+
+func TestValidate(t *testing.T) {
+	age := 16
+	e := Validate(&signupReq{Name: "k", Email: "kinoko", Age: &age, Role: "root", Code: "12345", Addresses: []address{{City: "x"}, {}}})
+	invalid, ok := e.(*BindingError)
+	if !ok {
+		t.Fatalf("%v", e)
+	}
+	expected := []FieldError{
+		{Field: "name", Rule: "min", Message: "length must be at least 2"},
+		{Field: "email", Rule: "email", Message: "must be a valid email address"},
+		{Field: "age", Rule: "min", Message: "must be at least 18"},
+		{Field: "role", Rule: "oneof", Message: "must be one of [user admin]"},
+		{Field: "code", Rule: "len", Message: "length must be 4"},
+		{Field: "addresses[1].city", Rule: "required", Message: "is required"},
+	}
+	if !reflect.DeepEqual(invalid.Errors, expected) {
+		t.Errorf("%+v", invalid.Errors)
+	}
+
+	if e := Validate(&signupReq{Name: "kinoko", Role: "user", Code: "1234"}); e != nil {
+		t.Errorf("valid request: %v", e)
+	}
+}
+
+type misspelledReq struct {
+	Inner struct {
+		Name string `validate:"requried"`
+	}
+}
+
+func TestUnknownRuleFailsMapping(t *testing.T) {
+	if e := Validate(&misspelledReq{}); e == nil || !strings.Contains(e.Error(), "unknown validation rule 'requried'") {
+		t.Errorf("Validate: %v", e)
+	}
+
+	for name, fn := range map[string]interface{}{
+		"body":  func(body *misspelledReq) {},
+		"query": func(q misspelledReq) {},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(r.(string), "requried") {
+					t.Errorf("%s: %v", name, r)
+				}
+			}()
+			newTestServer().Bind(Post, "/", fn)
+		}()
+	}
+}
+
+func TestValidateEmptyBody(t *testing.T) {
+	s := newTestServer()
+	s.Bind(Post, "/signup", func(body *signupReq) string {
+		return "signed up"
+	})
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest("POST", "/signup", nil),
+		httptest.NewRequest("POST", "/signup", strings.NewReader("")),
+	} {
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.handlers.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `{"field":"name","source":"body","rule":"required","message":"is required"}`) {
+			t.Errorf("%d %s", w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestValidateQuery(t *testing.T) {
+	s := newTestServer()
+	s.Bind(Get, "/search", func(q struct {
+		Page int `query:"page" validate:"min=1"`
+	}) string {
+		return "found"
+	})
+
+	w := serve(s, "GET", "/search?page=0", nil)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `{"field":"page","source":"query","rule":"min","message":"must be at least 1"}`) {
+		t.Errorf("%d %s", w.Code, w.Body.String())
+	}
+	if w := serve(s, "GET", "/search?page=1", nil); w.Body.String() != "found" {
+		t.Errorf("%d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// html views, templates are named by their path relative to the directory without extension
+//
+//	kinoko:
+//	  web:
+//	    view:
+//	      dir: templates
+//	      extension: .html
+//	      layout: layouts/main
+//	      partials: partials
+//	      reload: false
+//
+// a view is rendered as the "content" template of its layout, eg: {{template "content" .}}
+// partials are shared by every view, eg: {{template "partials/header" .}}
+// templates are cached unless reload is set, then they are parsed again once changed
+type ViewConfig struct {
+	Dir       string `inject:"kinoko.web.view.dir:templates"`
+	Extension string `inject:"kinoko.web.view.extension:.html"`
+	Layout    string `inject:"kinoko.web.view.layout:"`
+	Partials  string `inject:"kinoko.web.view.partials:partials"`
+	Reload    bool   `inject:"kinoko.web.view.reload:false"`
+}
+
+// functions available in every template, register it as a kinoko spore
+type TemplateFuncs interface {
+	TemplateFuncs() template.FuncMap
+}
+
+// html view returned by handlers, rendered as text/html by the default response resolver
+//
+//	eg: return View("users/show", user)
+type ViewResponse struct {
+	Name  string
+	Model interface{}
+
+	// layout overriding the configured one, see WithLayout
+	layout    string
+	hasLayout bool
+}
+
+func View(name string, model interface{}) *ViewResponse {
+	return &ViewResponse{Name: name, Model: model}
+}
+
+// render the view in another layout, empty renders the view alone
+func (v *ViewResponse) WithLayout(layout string) *ViewResponse {
+	v.layout, v.hasLayout = layout, true
+	return v
+}
+
+type viewEngine struct {
+	config ViewConfig
+	funcs  template.FuncMap
+
+	sync.RWMutex
+	cache map[string]*compiledView
+}
+
+type compiledView struct {
+	template *template.Template
+
+	// modification time of parsed files, checked if reloading
+	files map[string]time.Time
+}
+
+func newViewEngine(config ViewConfig, funcs template.FuncMap) *viewEngine {
+	if config.Extension != "" && !strings.HasPrefix(config.Extension, ".") {
+		config.Extension = "." + config.Extension
+	}
+	return &viewEngine{config: config, funcs: funcs, cache: map[string]*compiledView{}}
+}
+
+func (e *viewEngine) render(wr http.ResponseWriter, v *ViewResponse) error {
+	layout := e.config.Layout
+	if v.hasLayout {
+		layout = v.layout
+	}
+	view, err := e.compiled(v.Name, layout)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := view.template.Execute(buf, v.Model); err != nil {
+		return err
+	}
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = buf.WriteTo(wr)
+	return err
+}
+
+// cached template of the view in the layout, parsed again if reloading and any file changed
+func (e *viewEngine) compiled(name string, layout string) (*compiledView, error) {
+	key := layout + "|" + name
+	e.RLock()
+	view := e.cache[key]
+	e.RUnlock()
+	if view != nil && (!e.config.Reload || !view.changed()) {
+		return view, nil
+	}
+
+	view, err := e.parse(name, layout)
+	if err != nil {
+		return nil, err
+	}
+	e.Lock()
+	e.cache[key] = view
+	e.Unlock()
+	return view, nil
+}
+
+func (v *compiledView) changed() bool {
+	for file, modified := range v.files {
+		info, e := os.Stat(file)
+		if e != nil || !info.ModTime().Equal(modified) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *viewEngine) parse(name string, layout string) (*compiledView, error) {
+	view := &compiledView{files: map[string]time.Time{}}
+	viewFile, err := e.file(name)
+	if err != nil {
+		return nil, err
+	}
+
+	root := "content"
+	if layout != "" {
+		root = layout
+	}
+	t := template.New(root).Funcs(e.funcs)
+	content := t
+	if layout != "" {
+		layoutFile, err := e.file(layout)
+		if err != nil {
+			return nil, err
+		}
+		if err := view.parseFile(t, layoutFile); err != nil {
+			return nil, err
+		}
+		content = t.New("content")
+	}
+	if err := view.parseFile(content, viewFile); err != nil {
+		return nil, err
+	}
+
+	if e.config.Partials != "" {
+		partials := filepath.Join(e.config.Dir, filepath.FromSlash(e.config.Partials))
+		if err := filepath.Walk(partials, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && file == partials {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() || filepath.Ext(file) != e.config.Extension {
+				return nil
+			}
+			rel, err := filepath.Rel(e.config.Dir, file)
+			if err != nil {
+				return err
+			}
+			return view.parseFile(t.New(strings.TrimSuffix(filepath.ToSlash(rel), e.config.Extension)), file)
+		}); err != nil {
+			return nil, err
+		}
+		view.files[partials] = modTime(partials)
+	}
+
+	view.template = t
+	return view, nil
+}
+
+func (v *compiledView) parseFile(t *template.Template, file string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if _, err := t.Parse(string(content)); err != nil {
+		return err
+	}
+	v.files[file] = modTime(file)
+	return nil
+}
+
+// file of the template, names escaping the directory are rejected
+func (e *viewEngine) file(name string) (string, error) {
+	clean := path.Clean("/" + name)
+	if clean == "/" || clean != "/"+strings.TrimPrefix(name, "/") {
+		return "", fmt.Errorf("invalid view name %s", name)
+	}
+	return filepath.Join(e.config.Dir, filepath.FromSlash(clean[1:])+e.config.Extension), nil
+}
+
+func modTime(file string) time.Time {
+	if info, e := os.Stat(file); e == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
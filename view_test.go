@@ -0,0 +1,148 @@
+/*
+ * Copyright 2019 Azz. All rights reserved.
+ * Use of this source code is governed by a GPL-3.0
+ * license that can be found in the LICENSE file.
+ */
+
+package kinoko_web
+
+import (
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// directory of templates, removed by the returned function
+func viewDir(t *testing.T) (string, func()) {
+	dir, e := ioutil.TempDir("", "kinoko-views")
+	if e != nil {
+		t.Fatal(e)
+	}
+	for name, content := range map[string]string{
+		"layouts/main.html":    `<main>{{template "partials/header" .}}{{template "content" .}}</main>`,
+		"partials/header.html": `<h1>{{shout .Title}}</h1>`,
+		"users/show.html":      `<p>{{.Name}}</p>`,
+		"broken.html":          `{{.Missing.Field}}`,
+	} {
+		writeView(t, dir, name, content)
+	}
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func writeView(t *testing.T, dir, name, content string) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if e := os.MkdirAll(filepath.Dir(p), 0755); e != nil {
+		t.Fatal(e)
+	}
+	if e := ioutil.WriteFile(p, []byte(content), 0644); e != nil {
+		t.Fatal(e)
+	}
+}
+
+type page struct {
+	Title string
+	Name  string
+}
+
+var shout = template.FuncMap{"shout": strings.ToUpper}
+
+func TestViewLayouts(t *testing.T) {
+	dir, remove := viewDir(t)
+	defer remove()
+	views := newViewEngine(ViewConfig{Dir: dir, Extension: "html", Layout: "layouts/main", Partials: "partials"}, shout)
+
+	for _, c := range []struct {
+		view     *ViewResponse
+		expected string
+	}{
+		{View("users/show", page{"users", "alice"}), "<main><h1>USERS</h1><p>alice</p></main>"},
+		{View("users/show", page{"users", "<b>"}).WithLayout(""), "<p>&lt;b&gt;</p>"},
+		{View("/users/show", page{"users", "bob"}), "<main><h1>USERS</h1><p>bob</p></main>"},
+	} {
+		w := httptest.NewRecorder()
+		if e := views.render(w, c.view); e != nil {
+			t.Fatal(e)
+		}
+		if w.Body.String() != c.expected || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+			t.Errorf("%s: %q", c.view.Name, w.Body.String())
+		}
+	}
+}
+
+func TestViewNames(t *testing.T) {
+	dir, remove := viewDir(t)
+	defer remove()
+	views := newViewEngine(ViewConfig{Dir: dir, Extension: ".html"}, shout)
+
+	for _, name := range []string{"", "/", "../secret", "users/../../secret", "users//show", "missing"} {
+		if e := views.render(httptest.NewRecorder(), View(name, nil)); e == nil {
+			t.Errorf("%q is rendered", name)
+		}
+	}
+}
+
+func TestViewReload(t *testing.T) {
+	dir, remove := viewDir(t)
+	defer remove()
+
+	for _, reload := range []bool{false, true} {
+		writeView(t, dir, "users/show.html", `<p>{{.Name}}</p>`)
+		views := newViewEngine(ViewConfig{Dir: dir, Extension: ".html", Reload: reload}, shout)
+		render := func() string {
+			w := httptest.NewRecorder()
+			if e := views.render(w, View("users/show", page{Name: "alice"})); e != nil {
+				t.Fatal(e)
+			}
+			return w.Body.String()
+		}
+		render()
+
+		writeView(t, dir, "users/show.html", `<em>{{.Name}}</em>`)
+		// modification times are not precise on every file system
+		later := time.Now().Add(time.Minute)
+		if e := os.Chtimes(filepath.Join(dir, "users", "show.html"), later, later); e != nil {
+			t.Fatal(e)
+		}
+
+		expected := "<p>alice</p>"
+		if reload {
+			expected = "<em>alice</em>"
+		}
+		if body := render(); body != expected {
+			t.Errorf("reload %v: %q, expected %q", reload, body, expected)
+		}
+	}
+}
+
+func TestViewResponses(t *testing.T) {
+	dir, remove := viewDir(t)
+	defer remove()
+
+	s := newTestServer()
+	s.GET("/users/:name", func(ctx *RequestCtx) interface{} {
+		return View("users/show", page{"users", ctx.PathVariable["name"]})
+	})
+	s.GET("/broken", func(ctx *RequestCtx) interface{} {
+		return View("broken", page{})
+	})
+
+	if w := serve(s, "GET", "/users/alice", nil); w.Code != http.StatusInternalServerError {
+		t.Errorf("views not configured: %d", w.Code)
+	}
+
+	s.handlers.views = newViewEngine(ViewConfig{Dir: dir, Extension: ".html", Layout: "layouts/main", Partials: "partials"}, shout)
+	if w := serve(s, "GET", "/users/alice", nil); w.Code != http.StatusOK || w.Body.String() != "<main><h1>USERS</h1><p>alice</p></main>" {
+		t.Errorf("%d %q", w.Code, w.Body.String())
+	}
+	// nothing of a failed view is written
+	w := serve(s, "GET", "/broken", http.Header{"Accept": {"application/json"}})
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "failed to render view broken") {
+		t.Errorf("%d %q", w.Code, w.Body.String())
+	}
+}
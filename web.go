@@ -3,7 +3,9 @@ package kinoko_web
 import (
 	"container/list"
 	"context"
+	"fmt"
 	"github.com/kinoko-projects/kinoko"
+	"html/template"
 )
 
 func (s *HttpServer) Start(ctx context.Context) {
@@ -13,14 +15,99 @@ func (s *HttpServer) Start(ctx context.Context) {
 
 func (s *HttpServer) Initialize() error {
 	s.handlers = &RequestHandler{responseResolver: list.New()}
+	if s.CORSConfig != nil {
+		s.handlers.cors = s.CORSConfig.Policy()
+	}
+	s.handlers.router = s.RouterConfig
+	if s.ErrorConfig != nil {
+		s.handlers.exposeStacktrace = s.ErrorConfig.ExposeStacktrace
+	}
+	rules := kinoko.Application.GetImplementedSpores((*ValidationRule)(nil))
+	for _, rule := range rules {
+		RegisterValidationRule(rule.(ValidationRule))
+	}
+
+	decoders := kinoko.Application.GetImplementedSpores((*BodyDecoder)(nil))
+	for _, decoder := range decoders {
+		RegisterBodyDecoder(decoder.(BodyDecoder))
+	}
+
+	encoders := kinoko.Application.GetImplementedSpores((*ResponseEncoder)(nil))
+	for _, encoder := range encoders {
+		RegisterResponseEncoder(encoder.(ResponseEncoder))
+	}
+
+	renderers := kinoko.Application.GetImplementedSpores((*ErrorRenderer)(nil))
+	for _, renderer := range renderers {
+		RegisterErrorRenderer(renderer.(ErrorRenderer))
+	}
+
+	panicHandlers := kinoko.Application.GetImplementedSpores((*PanicHandler)(nil))
+	for _, panicHandler := range panicHandlers {
+		s.handlers.panicHandlers = append(s.handlers.panicHandlers, panicHandler.(PanicHandler))
+	}
+
+	constraints := kinoko.Application.GetImplementedSpores((*PathConstraint)(nil))
+	for _, constraint := range constraints {
+		RegisterPathConstraint(constraint.(PathConstraint))
+	}
+
+	templateFuncs := kinoko.Application.GetImplementedSpores((*TemplateFuncs)(nil))
+	if s.ViewConfig != nil {
+		funcs := template.FuncMap{}
+		for _, templateFunc := range templateFuncs {
+			for name, fn := range templateFunc.(TemplateFuncs).TemplateFuncs() {
+				funcs[name] = fn
+			}
+		}
+		s.handlers.views = newViewEngine(*s.ViewConfig, funcs)
+	}
+
 	controllers := kinoko.Application.GetImplementedSpores((*HttpController)(nil))
 	for _, controller := range controllers {
 		controller.(HttpController).Mapping(s)
 	}
+	s.mapAdminEndpoints()
+	if len(s.mappingErrors) > 0 {
+		return fmt.Errorf("%d routes can not be mapped, first: %v", len(s.mappingErrors), s.mappingErrors[0])
+	}
+
+	// the global chain is frozen once interceptor spores are added, routes select their interceptors once
+	chain := &s.handlers.interceptorChain
+	auth, e := s.authInterceptor()
+	if e != nil {
+		return e
+	}
+	// access control is never skipped by other interceptors
+	if auth != nil {
+		chain.addGuard(auth)
+	}
+	authorize, e := newAuthorizeInterceptor(permissionEvaluators())
+	if e != nil {
+		return e
+	}
+	if e := authorize.validate(s.Routes()); e != nil {
+		return e
+	}
+	chain.addGuard(authorize)
 
 	interceptors := kinoko.Application.GetImplementedSpores((*Interceptor)(nil))
+	sortSpores(interceptors)
 	for _, interceptor := range interceptors {
-		s.AddInterceptor(interceptor.(Interceptor))
+		chain.AddInterceptor(interceptor.(Interceptor))
+	}
+
+	aroundInterceptors := kinoko.Application.GetImplementedSpores((*AroundInterceptor)(nil))
+	sortSpores(aroundInterceptors)
+	for _, interceptor := range aroundInterceptors {
+		chain.AddAroundInterceptor(interceptor.(AroundInterceptor))
+	}
+	if e := chain.Freeze(); e != nil {
+		return e
+	}
+	s.reselectInterceptors(routeScope{}, chain)
+	for _, line := range chain.Dump() {
+		logger.Info("Interceptor", line)
 	}
 
 	responseResolvers := kinoko.Application.GetImplementedSpores((*ResponseResolver)(nil))